@@ -2,33 +2,93 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/userfile"
 )
 
-const maxFileSizeKiloBytes = 256
-
 func Init(handler *Handler, engine *engine.Engine) {
 	handlersRouter := handler.Router.PathPrefix("/handlers").Subrouter()
 	handlersRouter.HandleFunc(
 		"/channel_bulk_add",
 		checkAuthenticatedUser(injectEngine(handler.channelBulkAddHandler, engine)),
 	).Methods("POST")
+	handlersRouter.HandleFunc(
+		"/channel_bulk_remove",
+		checkAuthenticatedUser(injectEngine(handler.channelBulkRemoveHandler, engine)),
+	).Methods("POST")
+	handlersRouter.HandleFunc(
+		"/channel_sync",
+		checkAuthenticatedUser(injectEngine(handler.channelSyncHandler, engine)),
+	).Methods("POST")
+	handlersRouter.HandleFunc(
+		"/channel_copy_members",
+		checkAuthenticatedUser(injectEngine(handler.channelCopyMembersHandler, engine)),
+	).Methods("POST")
+	handlersRouter.HandleFunc(
+		"/jobs",
+		checkAuthenticatedUser(injectEngine(handler.listJobsHandler, engine)),
+	).Methods("GET")
+	handlersRouter.HandleFunc(
+		"/jobs/{id}",
+		checkAuthenticatedUser(injectEngine(handler.getJobHandler, engine)),
+	).Methods("GET")
+	handlersRouter.HandleFunc(
+		"/jobs/{id}/report",
+		checkAuthenticatedUser(injectEngine(handler.getJobReportHandler, engine)),
+	).Methods("GET")
+	handlersRouter.HandleFunc(
+		"/jobs/{id}/cancel",
+		checkAuthenticatedUser(injectEngine(handler.cancelJobHandler, engine)),
+	).Methods("POST")
+	handlersRouter.HandleFunc(
+		"/jobs/{id}/undo",
+		checkAuthenticatedUser(injectEngine(handler.undoJobHandler, engine)),
+	).Methods("POST")
+	handlersRouter.HandleFunc(
+		"/schedules",
+		checkAuthenticatedUser(injectEngine(handler.listSchedulesHandler, engine)),
+	).Methods("GET")
+	handlersRouter.HandleFunc(
+		"/schedules",
+		checkAuthenticatedUser(injectEngine(handler.createScheduleHandler, engine)),
+	).Methods("POST")
+	handlersRouter.HandleFunc(
+		"/schedules/{id}",
+		checkAuthenticatedUser(injectEngine(handler.deleteScheduleHandler, engine)),
+	).Methods("DELETE")
+	handlersRouter.HandleFunc(
+		"/locks/{channel_id}",
+		checkAuthenticatedUser(injectEngine(handler.getChannelLockHandler, engine)),
+	).Methods("GET")
+	handlersRouter.HandleFunc(
+		"/locks/{channel_id}",
+		checkAuthenticatedUser(injectEngine(handler.releaseChannelLockHandler, engine)),
+	).Methods("DELETE")
 }
 
 type bulkAddChannelPayload struct {
-	ChannelID string           `json:"channel_id"`
-	AddToTeam bool             `json:"add_to_team"`
-	Users     []engine.AddUser `json:"users"`
+	ChannelID  string           `json:"channel_id"`
+	ChannelIDs []string         `json:"channel_ids"`
+	AddToTeam  bool             `json:"add_to_team"`
+	DryRun     bool             `json:"dry_run"`
+	Users      []engine.AddUser `json:"users"`
+
+	// RemoveExtras on syncs, remove the channel members that are not in the file
+	RemoveExtras bool `json:"remove_extras"`
+
+	// GuestPolicy how guests are treated, the policy of the plugin configuration if empty
+	GuestPolicy engine.GuestPolicy `json:"guest_policy"`
 }
 
 func (bip *bulkAddChannelPayload) IsValid() *perror.PError {
-	if bip.ChannelID == "" {
-		return perror.NewPError(fmt.Errorf("missing channel_id"), "Channel ID is required.")
+	if err := validateChannelIDs(bip.ChannelID, bip.ChannelIDs); err != nil {
+		return err
 	}
 
 	if len(bip.Users) == 0 {
@@ -47,32 +107,49 @@ func (bip *bulkAddChannelPayload) FromRequest(r *http.Request) *perror.PError {
 		return perror.NewPError(err, "error parsing file")
 	}
 
-	if h.Size > maxFileSizeKiloBytes*1024 {
-		return perror.NewPError(fmt.Errorf("file too large"), fmt.Sprintf("File is too large. Max file size is %dKB.", maxFileSizeKiloBytes))
-	}
-
-	if h.Header.Get("Content-Type") != "application/json" {
-		return perror.NewPError(fmt.Errorf("invalid content type"), "Invalid file type, only JSON is supported")
+	if h.Size > userfile.MaxSizeKiloBytes*1024 {
+		return perror.NewPError(fmt.Errorf("file too large"), fmt.Sprintf("File is too large. Max file size is %dKB.", userfile.MaxSizeKiloBytes))
 	}
 
-	if err := json.NewDecoder(f).Decode(&bip); err != nil {
-		return perror.NewPError(err, "Error parsing submitted file")
+	users, perr := userfile.Parse(f, userfile.Format(h.Header.Get("Content-Type"), h.Filename))
+	if perr != nil {
+		return perr
 	}
+	bip.Users = users
 
 	bip.ChannelID = r.FormValue("channel_id")
+	bip.ChannelIDs = parseChannelIDs(r.Form["channel_ids"])
 	bip.AddToTeam = r.FormValue("add_to_team") == "true"
+	bip.DryRun = r.FormValue("dry_run") == "true"
+	bip.RemoveExtras = r.FormValue("remove_extras") == "true"
+	bip.GuestPolicy = engine.GuestPolicy(r.FormValue("guest_policy"))
 
 	return nil
 }
 
 func (h *Handler) channelBulkAddHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	h.channelBulkOperationHandler(w, r, e, engine.OperationAdd)
+}
+
+func (h *Handler) channelBulkRemoveHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	h.channelBulkOperationHandler(w, r, e, engine.OperationRemove)
+}
+
+// channelSyncHandler makes the users of the uploaded file the members of the channel
+func (h *Handler) channelSyncHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	h.channelBulkOperationHandler(w, r, e, engine.OperationSync)
+}
+
+// channelBulkOperationHandler starts a job applying the operation to the users of the uploaded file,
+// or returns the predicted outcome on dry runs
+func (h *Handler) channelBulkOperationHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine, operation engine.Operation) {
 	userID := getMattermostUserIDFromRequest(r)
 
 	defer r.Body.Close()
 
 	// Do not parse data in memory
 	if err := r.ParseMultipartForm(0); err != nil {
-		h.Logger.LogError("error parsing channel bulk add form", "err", err.Error())
+		h.Logger.LogError("error parsing channel bulk form", "operation", string(operation), "err", err.Error())
 		sendInternalServerError(w)
 		return
 	}
@@ -80,35 +157,97 @@ func (h *Handler) channelBulkAddHandler(w http.ResponseWriter, r *http.Request,
 	var payload bulkAddChannelPayload
 
 	if err := payload.FromRequest(r); err != nil {
-		h.Logger.LogError("error parsing channel bulk add form payload", "err", err.Error())
+		h.Logger.LogError("error parsing channel bulk form payload", "operation", string(operation), "err", err.Error())
 		sendResponse(w,
 			withHeader("Content-Type", "application/json"),
 			withStatusCode(http.StatusBadRequest),
-			withBody(err.AsJSON()),
+			withBody("%s", err.AsJSON()),
 		)
 		return
 	}
 
 	if err := payload.IsValid(); err != nil {
-		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody(err.AsJSON()))
+		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody("%s", err.AsJSON()))
 		return
 	}
 
 	engineConfig := &engine.Config{
-		UserID:    userID,
-		ChannelID: payload.ChannelID,
-		AddToTeam: payload.AddToTeam,
-		Users:     payload.Users,
+		Operation:    operation,
+		UserID:       userID,
+		ChannelID:    payload.ChannelID,
+		ChannelIDs:   payload.ChannelIDs,
+		AddToTeam:    payload.AddToTeam,
+		RemoveExtras: payload.RemoveExtras,
+		GuestPolicy:  payload.GuestPolicy,
+		Users:        payload.Users,
 	}
 
-	if err := e.StartJob(context.TODO(), engineConfig); err != nil {
+	h.startBulkOperation(w, e, engineConfig, payload.DryRun)
+}
+
+// startBulkOperation starts a job for the config, or returns the predicted outcome on dry runs
+func (h *Handler) startBulkOperation(w http.ResponseWriter, e *engine.Engine, engineConfig *engine.Config, dryRun bool) {
+	if dryRun {
+		dryRunResult, err := e.DryRun(engineConfig)
+		if err != nil {
+			sendResponse(w,
+				withHeader("Content-Type", "application/json"),
+				withStatusCode(http.StatusBadRequest),
+				withBody("%s", err.AsJSON()),
+			)
+			return
+		}
+
+		sendJSONResponse(w, http.StatusOK, dryRunResult)
+		return
+	}
+
+	job, err := e.StartJob(context.Background(), engineConfig)
+	if err != nil {
 		sendResponse(w,
 			withHeader("Content-Type", "application/json"),
 			withStatusCode(http.StatusBadRequest),
-			withBody(err.AsJSON()),
+			withBody("%s", err.AsJSON()),
 		)
 		return
 	}
 
-	sendResponse(w, withStatusCode(http.StatusCreated), withBody(`{"message": "bulk add job started"}`))
+	sendJSONResponse(w, http.StatusCreated, job)
+}
+
+// parseForm parses the URL encoded and the multipart forms without keeping files in memory, so
+// repeated fields like channel_ids are read from both
+func parseForm(r *http.Request) error {
+	if err := r.ParseMultipartForm(0); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+	return nil
+}
+
+// validateChannelIDs checks that the target channels are set either with channel_id or channel_ids
+func validateChannelIDs(channelID string, channelIDs []string) *perror.PError {
+	if channelID == "" && len(channelIDs) == 0 {
+		return perror.NewPError(fmt.Errorf("missing channel_id"), "Channel ID is required.")
+	}
+
+	if channelID != "" && len(channelIDs) > 0 {
+		return perror.NewPError(fmt.Errorf("both channel_id and channel_ids"), "Set either channel_id or channel_ids, not both.")
+	}
+
+	return nil
+}
+
+// parseChannelIDs reads the channel IDs of the channel_ids form field, which can be repeated or
+// contain comma separated IDs
+func parseChannelIDs(values []string) []string {
+	channelIDs := []string{}
+	for _, value := range values {
+		for _, channelID := range strings.Split(value, ",") {
+			if channelID = strings.TrimSpace(channelID); channelID != "" {
+				channelIDs = append(channelIDs, channelID)
+			}
+		}
+	}
+
+	return channelIDs
 }
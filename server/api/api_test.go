@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkAddChannelPayloadChannelIDs(t *testing.T) {
+	parsePayload := func(t *testing.T, fields map[string]string) *bulkAddChannelPayload {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for name, value := range fields {
+			require.NoError(t, writer.WriteField(name, value))
+		}
+		file, err := writer.CreateFormFile("file", "users.txt")
+		require.NoError(t, err)
+		_, err = file.Write([]byte("@username\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		r := httptest.NewRequest("POST", "/handlers/channel_bulk_add", body)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		require.NoError(t, r.ParseMultipartForm(0))
+
+		payload := &bulkAddChannelPayload{}
+		require.Nil(t, payload.FromRequest(r))
+		return payload
+	}
+
+	t.Run("channel_id or channel_ids should be valid", func(t *testing.T) {
+		require.Nil(t, parsePayload(t, map[string]string{"channel_id": "channel-1"}).IsValid())
+
+		payload := parsePayload(t, map[string]string{"channel_ids": "channel-1, channel-2"})
+		require.Nil(t, payload.IsValid())
+		require.Equal(t, []string{"channel-1", "channel-2"}, payload.ChannelIDs)
+	})
+
+	t.Run("missing channels should fail", func(t *testing.T) {
+		err := parsePayload(t, map[string]string{}).IsValid()
+		require.NotNil(t, err)
+		require.Equal(t, "Channel ID is required.", err.Message())
+	})
+
+	t.Run("both channel_id and channel_ids should fail", func(t *testing.T) {
+		err := parsePayload(t, map[string]string{"channel_id": "channel-1", "channel_ids": "channel-2"}).IsValid()
+		require.NotNil(t, err)
+		require.Equal(t, "Set either channel_id or channel_ids, not both.", err.Message())
+	})
+}
+
+func TestCopyChannelMembersPayloadFromRequest(t *testing.T) {
+	t.Run("multipart forms should be parsed", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		require.NoError(t, writer.WriteField("source_channel_id", "source"))
+		require.NoError(t, writer.WriteField("channel_ids", "channel-1"))
+		require.NoError(t, writer.WriteField("channel_ids", "channel-2"))
+		require.NoError(t, writer.Close())
+
+		r := httptest.NewRequest("POST", "/handlers/channel_copy_members", body)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		require.NoError(t, parseForm(r))
+
+		var payload copyChannelMembersPayload
+		payload.FromRequest(r)
+		require.Nil(t, payload.IsValid())
+		require.Equal(t, "source", payload.SourceChannelID)
+		require.Equal(t, []string{"channel-1", "channel-2"}, payload.ChannelIDs)
+	})
+
+	t.Run("URL encoded forms should be parsed", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/handlers/channel_copy_members", strings.NewReader("source_channel_id=source&channel_ids=channel-1,channel-2"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		require.NoError(t, parseForm(r))
+
+		var payload copyChannelMembersPayload
+		payload.FromRequest(r)
+		require.Nil(t, payload.IsValid())
+		require.Equal(t, []string{"channel-1", "channel-2"}, payload.ChannelIDs)
+	})
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+)
+
+type copyChannelMembersPayload struct {
+	SourceChannelID string   `json:"source_channel_id"`
+	SourceGroupID   string   `json:"source_group_id"`
+	SourceTeamID    string   `json:"source_team_id"`
+	ChannelID       string   `json:"channel_id"`
+	ChannelIDs      []string `json:"channel_ids"`
+	AddToTeam       bool     `json:"add_to_team"`
+	DryRun          bool     `json:"dry_run"`
+
+	// GuestPolicy how guests are treated, the policy of the plugin configuration if empty
+	GuestPolicy engine.GuestPolicy `json:"guest_policy"`
+}
+
+func (p *copyChannelMembersPayload) IsValid() *perror.PError {
+	if p.SourceChannelID == "" && p.SourceGroupID == "" && p.SourceTeamID == "" {
+		return perror.NewPError(fmt.Errorf("missing source"), "A source channel, group or team ID is required.")
+	}
+
+	if err := validateChannelIDs(p.ChannelID, p.ChannelIDs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *copyChannelMembersPayload) FromRequest(r *http.Request) {
+	p.SourceChannelID = r.FormValue("source_channel_id")
+	p.SourceGroupID = r.FormValue("source_group_id")
+	p.SourceTeamID = r.FormValue("source_team_id")
+	p.ChannelID = r.FormValue("channel_id")
+	p.ChannelIDs = parseChannelIDs(r.Form["channel_ids"])
+	p.AddToTeam = r.FormValue("add_to_team") == "true"
+	p.DryRun = r.FormValue("dry_run") == "true"
+	p.GuestPolicy = engine.GuestPolicy(r.FormValue("guest_policy"))
+}
+
+// channelCopyMembersHandler adds the members of the source channel, group or team to the target channels
+func (h *Handler) channelCopyMembersHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	userID := getMattermostUserIDFromRequest(r)
+
+	defer r.Body.Close()
+
+	if err := parseForm(r); err != nil {
+		h.Logger.LogError("error parsing channel copy members form", "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	var payload copyChannelMembersPayload
+	payload.FromRequest(r)
+
+	if err := payload.IsValid(); err != nil {
+		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody("%s", err.AsJSON()))
+		return
+	}
+
+	h.startBulkOperation(w, e, &engine.Config{
+		Operation:       engine.OperationAdd,
+		UserID:          userID,
+		ChannelID:       payload.ChannelID,
+		ChannelIDs:      payload.ChannelIDs,
+		SourceChannelID: payload.SourceChannelID,
+		SourceGroupID:   payload.SourceGroupID,
+		SourceTeamID:    payload.SourceTeamID,
+		AddToTeam:       payload.AddToTeam,
+		GuestPolicy:     payload.GuestPolicy,
+	}, payload.DryRun)
+}
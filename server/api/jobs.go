@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
+)
+
+const (
+	// defaultJobsPerPage, maxJobsPerPage the default and maximum number of jobs returned by the job list
+	defaultJobsPerPage = 20
+	maxJobsPerPage     = 100
+)
+
+// getRequestJob loads the job referenced in the request path, sending the error response if the job
+// can't be retrieved or the user can't access it.
+func (h *Handler) getRequestJob(w http.ResponseWriter, r *http.Request, e *engine.Engine) (*engine.Job, bool) {
+	userID := getMattermostUserIDFromRequest(r)
+	jobID := mux.Vars(r)["id"]
+
+	job, err := e.GetJob(userID, jobID)
+	if err != nil {
+		if errors.Is(err, kvstore.ErrNotFound) {
+			sendResponse(w, withStatusCode(http.StatusNotFound), withBody(`{"error": "job not found"}`))
+			return nil, false
+		}
+		h.Logger.LogError("error getting job", "job_id", jobID, "err", err.Error())
+		sendInternalServerError(w)
+		return nil, false
+	}
+
+	return job, true
+}
+
+func (h *Handler) getJobHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	job, ok := h.getRequestJob(w, r, e)
+	if !ok {
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, job)
+}
+
+func (h *Handler) getJobReportHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	job, ok := h.getRequestJob(w, r, e)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	report, err := e.GetJobReport(job.ID)
+	if err != nil {
+		h.Logger.LogError("error getting job report", "job_id", job.ID, "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	var data []byte
+	var contentType string
+	switch format {
+	case "json":
+		data, err = report.JSON()
+		contentType = "application/json"
+	case "csv":
+		data, err = report.CSV()
+		contentType = "text/csv"
+	default:
+		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody(`{"error": "invalid format, only json and csv are supported"}`))
+		return
+	}
+	if err != nil {
+		h.Logger.LogError("error generating job report", "job_id", job.ID, "format", format, "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	sendResponse(w,
+		withHeader("Content-Type", contentType),
+		withHeader("Content-Disposition", fmt.Sprintf("attachment; filename=%q", engine.ReportFilename(job, format))),
+		withStatusCode(http.StatusOK),
+		withBody("%s", data),
+	)
+}
+
+func (h *Handler) listJobsHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	userID := getMattermostUserIDFromRequest(r)
+	query := r.URL.Query()
+
+	page, err := parseQueryInt(query.Get("page"), 0)
+	if err != nil || page < 0 {
+		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody(`{"error": "invalid page"}`))
+		return
+	}
+
+	perPage, err := parseQueryInt(query.Get("per_page"), defaultJobsPerPage)
+	if err != nil || perPage <= 0 {
+		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody(`{"error": "invalid per_page"}`))
+		return
+	}
+	if perPage > maxJobsPerPage {
+		perPage = maxJobsPerPage
+	}
+
+	opts := engine.JobListOptions{
+		ChannelID: query.Get("channel_id"),
+		Page:      page,
+		PerPage:   perPage,
+	}
+	if !e.IsSystemAdmin(userID) {
+		opts.UserID = userID
+	}
+
+	jobs, err := e.ListJobs(opts)
+	if err != nil {
+		h.Logger.LogError("error listing jobs", "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, jobs)
+}
+
+// parseQueryInt parses an integer query parameter, returning the default value if it's empty
+func parseQueryInt(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (h *Handler) cancelJobHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	job, ok := h.getRequestJob(w, r, e)
+	if !ok {
+		return
+	}
+
+	if err := e.CancelJob(job); err != nil {
+		h.Logger.LogError("error cancelling job", "job_id", job.ID, "err", err.Error())
+		sendResponse(w,
+			withHeader("Content-Type", "application/json"),
+			withStatusCode(http.StatusBadRequest),
+			withBody("%s", err.AsJSON()),
+		)
+		return
+	}
+
+	sendResponse(w, withStatusCode(http.StatusAccepted), withBody(`{"message": "job cancellation requested"}`))
+}
+
+func (h *Handler) undoJobHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	job, ok := h.getRequestJob(w, r, e)
+	if !ok {
+		return
+	}
+
+	undoJob, err := e.UndoJob(context.Background(), job, getMattermostUserIDFromRequest(r))
+	if err != nil {
+		h.Logger.LogError("error undoing job", "job_id", job.ID, "err", err.Error())
+		sendResponse(w,
+			withHeader("Content-Type", "application/json"),
+			withStatusCode(http.StatusBadRequest),
+			withBody("%s", err.AsJSON()),
+		)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, undoJob)
+}
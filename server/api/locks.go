@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
+)
+
+// channelLockResponse the lock of a channel, with whether its job stopped renewing it
+type channelLockResponse struct {
+	ChannelID string `json:"channel_id"`
+	*kvstore.LockInfo
+	Stale bool `json:"stale"`
+}
+
+// checkSystemAdmin sends a forbidden response if the user of the request is not a system admin
+func checkSystemAdmin(w http.ResponseWriter, r *http.Request, e *engine.Engine) bool {
+	if !e.IsSystemAdmin(getMattermostUserIDFromRequest(r)) {
+		sendResponse(w, withStatusCode(http.StatusForbidden), withBody(`{"error": "only system admins can manage channel locks"}`))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) getChannelLockHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	if !checkSystemAdmin(w, r, e) {
+		return
+	}
+
+	channelID := mux.Vars(r)["channel_id"]
+	lock, err := e.GetChannelLock(channelID)
+	if err != nil {
+		if errors.Is(err, kvstore.ErrNotFound) {
+			sendResponse(w, withStatusCode(http.StatusNotFound), withBody(`{"error": "channel not locked"}`))
+			return
+		}
+		h.Logger.LogError("error getting channel lock", "channel_id", channelID, "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, channelLockResponse{
+		ChannelID: channelID,
+		LockInfo:  lock,
+		Stale:     lock.IsStale(),
+	})
+}
+
+// releaseChannelLockHandler releases a stale channel lock, so new jobs can run in the channel
+// without waiting for the lock to expire
+func (h *Handler) releaseChannelLockHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	if !checkSystemAdmin(w, r, e) {
+		return
+	}
+
+	channelID := mux.Vars(r)["channel_id"]
+	err := e.ReleaseStaleChannelLock(channelID)
+	switch {
+	case errors.Is(err, kvstore.ErrNotFound):
+		sendResponse(w, withStatusCode(http.StatusNotFound), withBody(`{"error": "channel not locked"}`))
+		return
+	case errors.Is(err, kvstore.ErrLockNotStale):
+		sendResponse(w, withStatusCode(http.StatusConflict), withBody(`{"error": "the lock is held by a running job"}`))
+		return
+	case err != nil:
+		h.Logger.LogError("error releasing channel lock", "channel_id", channelID, "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	h.Logger.LogInfo("stale channel lock released", "channel_id", channelID, "user_id", getMattermostUserIDFromRequest(r))
+	sendResponse(w, withStatusCode(http.StatusOK), withBody(`{"message": "channel lock released"}`))
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 )
@@ -34,3 +35,17 @@ func withBody(body string, args ...any) responseOption {
 func sendInternalServerError(w http.ResponseWriter) {
 	sendResponse(w, withStatusCode(http.StatusInternalServerError), withBody(`{"error": "internal server error"}`))
 }
+
+func sendJSONResponse(w http.ResponseWriter, statusCode int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		sendInternalServerError(w)
+		return
+	}
+
+	sendResponse(w,
+		withHeader("Content-Type", "application/json"),
+		withStatusCode(statusCode),
+		withBody("%s", data),
+	)
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+)
+
+// createSchedulePayload the operation to schedule, sent as a JSON body since the users are included
+type createSchedulePayload struct {
+	Operation       engine.Operation `json:"operation"`
+	ChannelID       string           `json:"channel_id"`
+	ChannelIDs      []string         `json:"channel_ids"`
+	Users           []engine.AddUser `json:"users"`
+	SourceChannelID string           `json:"source_channel_id"`
+	SourceGroupID   string           `json:"source_group_id"`
+	SourceTeamID    string           `json:"source_team_id"`
+	AddToTeam       bool             `json:"add_to_team"`
+	RemoveExtras    bool             `json:"remove_extras"`
+
+	// GuestPolicy how guests are treated, the policy of the plugin configuration when each run starts
+	// if empty
+	GuestPolicy engine.GuestPolicy `json:"guest_policy"`
+
+	// RunAt the time of the first run in milliseconds
+	RunAt int64 `json:"run_at"`
+
+	// IntervalHours the hours between runs, 0 to run once
+	IntervalHours int `json:"interval_hours"`
+}
+
+func (p *createSchedulePayload) IsValid() *perror.PError {
+	if err := validateChannelIDs(p.ChannelID, p.ChannelIDs); err != nil {
+		return err
+	}
+
+	if p.RunAt == 0 {
+		return perror.NewPError(fmt.Errorf("missing run_at"), "The scheduled time is required.")
+	}
+
+	return nil
+}
+
+// getRequestSchedule loads the schedule referenced in the request path, sending the error response
+// if the schedule can't be retrieved or the user can't access it.
+func (h *Handler) getRequestSchedule(w http.ResponseWriter, r *http.Request, e *engine.Engine) (*engine.Schedule, bool) {
+	userID := getMattermostUserIDFromRequest(r)
+	scheduleID := mux.Vars(r)["id"]
+
+	schedule, err := e.GetSchedule(userID, scheduleID)
+	if err != nil {
+		if errors.Is(err, kvstore.ErrNotFound) {
+			sendResponse(w, withStatusCode(http.StatusNotFound), withBody(`{"error": "schedule not found"}`))
+			return nil, false
+		}
+		h.Logger.LogError("error getting schedule", "schedule_id", scheduleID, "err", err.Error())
+		sendInternalServerError(w)
+		return nil, false
+	}
+
+	return schedule, true
+}
+
+func (h *Handler) createScheduleHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	userID := getMattermostUserIDFromRequest(r)
+
+	defer r.Body.Close()
+
+	var payload createSchedulePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody(`{"error": "invalid schedule payload"}`))
+		return
+	}
+
+	if err := payload.IsValid(); err != nil {
+		sendResponse(w, withStatusCode(http.StatusBadRequest), withBody("%s", err.AsJSON()))
+		return
+	}
+
+	schedule, err := e.CreateSchedule(&engine.Schedule{
+		Config: engine.Config{
+			Operation:       payload.Operation,
+			UserID:          userID,
+			ChannelID:       payload.ChannelID,
+			ChannelIDs:      payload.ChannelIDs,
+			Users:           payload.Users,
+			SourceChannelID: payload.SourceChannelID,
+			SourceGroupID:   payload.SourceGroupID,
+			SourceTeamID:    payload.SourceTeamID,
+			AddToTeam:       payload.AddToTeam,
+			GuestPolicy:     payload.GuestPolicy,
+			RemoveExtras:    payload.RemoveExtras,
+		},
+		RunAt:         payload.RunAt,
+		IntervalHours: payload.IntervalHours,
+	})
+	if err != nil {
+		sendResponse(w,
+			withHeader("Content-Type", "application/json"),
+			withStatusCode(http.StatusBadRequest),
+			withBody("%s", err.AsJSON()),
+		)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, schedule)
+}
+
+func (h *Handler) listSchedulesHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	userID := getMattermostUserIDFromRequest(r)
+	channelID := r.URL.Query().Get("channel_id")
+
+	schedules, err := e.ListSchedules()
+	if err != nil {
+		h.Logger.LogError("error listing schedules", "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	isSystemAdmin := e.IsSystemAdmin(userID)
+
+	result := []*engine.Schedule{}
+	for _, schedule := range schedules {
+		if channelID != "" && !schedule.TargetsChannel(channelID) {
+			continue
+		}
+
+		if !isSystemAdmin && schedule.Config.UserID != userID {
+			continue
+		}
+
+		result = append(result, schedule)
+	}
+
+	sendJSONResponse(w, http.StatusOK, result)
+}
+
+func (h *Handler) deleteScheduleHandler(w http.ResponseWriter, r *http.Request, e *engine.Engine) {
+	schedule, ok := h.getRequestSchedule(w, r, e)
+	if !ok {
+		return
+	}
+
+	if err := e.DeleteSchedule(schedule.ID); err != nil {
+		h.Logger.LogError("error deleting schedule", "schedule_id", schedule.ID, "err", err.Error())
+		sendInternalServerError(w)
+		return
+	}
+
+	sendResponse(w, withStatusCode(http.StatusOK), withBody(`{"message": "schedule deleted"}`))
+}
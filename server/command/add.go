@@ -0,0 +1,171 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/userfile"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func (h *Handler) executeAdd(args *model.CommandArgs, params []string) *model.CommandResponse {
+	addToTeam, params, perr := parseFlags(params)
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	if len(params) == 0 {
+		return responsef("Please provide the users to add: `/bulk-invite add @user1 @user2 user3@example.com`")
+	}
+
+	users := make([]engine.AddUser, 0, len(params))
+	for _, param := range params {
+		user, err := userfile.ParseUser(param)
+		if err != nil {
+			return responsef("Error adding users: %s.", err.Error())
+		}
+		users = append(users, user)
+	}
+
+	return h.startAddJob(args, users, addToTeam)
+}
+
+// executeAddFile adds the users of the last file uploaded by the user to the channel, since slash commands
+// can't receive files
+func (h *Handler) executeAddFile(args *model.CommandArgs, params []string) *model.CommandResponse {
+	addToTeam, params, perr := parseFlags(params)
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	if len(params) != 0 {
+		return responsef("Unexpected arguments. Upload the file with the users to the channel, then run `/bulk-invite add-file [%s]`.", flagAddToTeam)
+	}
+
+	users, perr := h.getLastUploadedUsers(args)
+	if perr != nil {
+		h.API.LogError("error reading uploaded users file", "user_id", args.UserId, "channel_id", args.ChannelId, "err", perr.Error())
+		return responsef("%s", perr.Message())
+	}
+
+	return h.startAddJob(args, users, addToTeam)
+}
+
+// getLastUploadedUsers parses the last file uploaded by the user to the channel
+func (h *Handler) getLastUploadedUsers(args *model.CommandArgs) ([]engine.AddUser, *perror.PError) {
+	fileInfos, appErr := h.API.GetFileInfos(0, 1, &model.GetFileInfosOptions{
+		UserIds:        []string{args.UserId},
+		ChannelIds:     []string{args.ChannelId},
+		SortBy:         model.FileinfoSortByCreated,
+		SortDescending: true,
+	})
+	if appErr != nil {
+		return nil, perror.NewInternalServerPError(fmt.Errorf("error getting uploaded files: %w", appErr))
+	}
+	if len(fileInfos) == 0 {
+		return nil, perror.NewPError(fmt.Errorf("missing file"), "Upload the file with the users to this channel before running the command.")
+	}
+
+	fileInfo := fileInfos[0]
+	if fileInfo.Size > userfile.MaxSizeKiloBytes*1024 {
+		return nil, perror.NewPError(fmt.Errorf("file too large"), fmt.Sprintf("File `%s` is too large. Max file size is %dKB.", fileInfo.Name, userfile.MaxSizeKiloBytes))
+	}
+
+	data, appErr := h.API.GetFile(fileInfo.Id)
+	if appErr != nil {
+		return nil, perror.NewInternalServerPError(fmt.Errorf("error getting file %s: %w", fileInfo.Id, appErr))
+	}
+
+	users, perr := userfile.Parse(bytes.NewReader(data), userfile.Format(fileInfo.MimeType, fileInfo.Name))
+	if perr != nil {
+		return nil, perr
+	}
+	if len(users) == 0 {
+		return nil, perror.NewPError(fmt.Errorf("missing users"), fmt.Sprintf("File `%s` has no users.", fileInfo.Name))
+	}
+
+	return users, nil
+}
+
+func (h *Handler) startAddJob(args *model.CommandArgs, users []engine.AddUser, addToTeam bool) *model.CommandResponse {
+	job, perr := h.engine.StartJob(context.Background(), &engine.Config{
+		Operation: engine.OperationAdd,
+		UserID:    args.UserId,
+		ChannelID: args.ChannelId,
+		Users:     users,
+		AddToTeam: addToTeam,
+	})
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	return responsef("Adding %d users to the channel. Job ID: `%s`. Use `/bulk-invite status %s` to check its progress.", job.TotalUsers, job.ID, job.ID)
+}
+
+// parseFlags extracts the flags from the command parameters, returning the remaining parameters
+func parseFlags(params []string) (bool, []string, *perror.PError) {
+	addToTeam := false
+	rest := []string{}
+	for _, param := range params {
+		switch {
+		case param == flagAddToTeam:
+			addToTeam = true
+		case strings.HasPrefix(param, "--"):
+			return false, nil, perror.NewPError(fmt.Errorf("unknown flag %s", param), fmt.Sprintf("Unknown flag `%s`.", param))
+		default:
+			rest = append(rest, param)
+		}
+	}
+
+	return addToTeam, rest, nil
+}
+
+func (h *Handler) executeCopy(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 2 {
+		return responsef("Please provide the source and target channels: `/bulk-invite copy ~source ~target`")
+	}
+
+	source, perr := h.getChannel(args.TeamId, params[0])
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	target, perr := h.getChannel(args.TeamId, params[1])
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	job, perr := h.engine.StartJob(context.Background(), &engine.Config{
+		Operation:       engine.OperationAdd,
+		UserID:          args.UserId,
+		ChannelID:       target.Id,
+		SourceChannelID: source.Id,
+	})
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	return responsef("Adding the %d members of ~%s to ~%s. Job ID: `%s`.", job.TotalUsers, source.Name, target.Name, job.ID)
+}
+
+// getChannel gets a channel of the team by its name, with or without the ~ prefix, or by its ID
+func (h *Handler) getChannel(teamID, nameOrID string) (*model.Channel, *perror.PError) {
+	name := strings.TrimPrefix(nameOrID, "~")
+
+	channel, appErr := h.API.GetChannelByName(teamID, name, false)
+	if appErr == nil {
+		return channel, nil
+	}
+
+	if model.IsValidId(name) {
+		if channel, appErr = h.API.GetChannel(name); appErr == nil {
+			return channel, nil
+		}
+	}
+
+	return nil, perror.NewPError(fmt.Errorf("channel %s not found", nameOrID), fmt.Sprintf("Channel `%s` not found.", nameOrID))
+}
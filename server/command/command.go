@@ -0,0 +1,132 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	commandTrigger = "bulk-invite"
+
+	helpText = "###### Bulk Invite - Slash Command Help\n" +
+		"- `/bulk-invite add [--add-to-team] @user1 @user2 user3@example.com` - Add the users to the current channel\n" +
+		"- `/bulk-invite add-file [--add-to-team]` - Add the users of the last file you uploaded to the current channel\n" +
+		"- `/bulk-invite copy ~source ~target` - Add the members of the source channel to the target channel\n" +
+		"- `/bulk-invite status [job id]` - Show the progress of a bulk job, the last one of the current channel by default\n" +
+		"- `/bulk-invite cancel <job id>` - Cancel a queued or running bulk job\n" +
+		"- `/bulk-invite undo <job id>` - Remove the users added by a finished bulk job from the channel and the team\n" +
+		"- `/bulk-invite history` - List the last bulk jobs of the current channel\n" +
+		"- `/bulk-invite help` - Show this help text"
+
+	// flagAddToTeam adds the users that don't belong to the team of the channel to it
+	flagAddToTeam = "--add-to-team"
+)
+
+type Handler struct {
+	API plugin.API
+
+	engine *engine.Engine
+}
+
+func NewHandler(pluginAPI plugin.API, e *engine.Engine) *Handler {
+	return &Handler{
+		API:    pluginAPI,
+		engine: e,
+	}
+}
+
+// Register registers the slash command in the server
+func (h *Handler) Register() error {
+	if err := h.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Bulk Invite",
+		Description:      "Manage bulk operations on channels",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: add, add-file, copy, status, cancel, undo, history, help",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: getAutocompleteData(),
+	}); err != nil {
+		return fmt.Errorf("error registering command: %w", err)
+	}
+
+	return nil
+}
+
+func getAutocompleteData() *model.AutocompleteData {
+	command := model.NewAutocompleteData(commandTrigger, "[command]", "Available commands: add, add-file, copy, status, cancel, undo, history, help")
+
+	add := model.NewAutocompleteData("add", "[--add-to-team] @user1 @user2", "Add the users to the current channel")
+	add.AddTextArgument("Usernames or emails of the users to add", "[--add-to-team] @user1 @user2", "")
+	command.AddCommand(add)
+
+	addFile := model.NewAutocompleteData("add-file", "[--add-to-team]", "Add the users of the last file you uploaded to the current channel")
+	addFile.AddStaticListArgument("Add the users that don't belong to the team to it", false, []model.AutocompleteListItem{
+		{Item: flagAddToTeam, HelpText: "Add the users that don't belong to the team to it"},
+	})
+	command.AddCommand(addFile)
+
+	copyMembers := model.NewAutocompleteData("copy", "~source ~target", "Add the members of the source channel to the target channel")
+	copyMembers.AddTextArgument("Channel to copy the members from", "~source", "")
+	copyMembers.AddTextArgument("Channel to add the members to", "~target", "")
+	command.AddCommand(copyMembers)
+
+	status := model.NewAutocompleteData("status", "[job id]", "Show the progress of a bulk job")
+	status.AddTextArgument("ID of the job, the last job of the current channel if empty", "[job id]", "")
+	command.AddCommand(status)
+
+	cancel := model.NewAutocompleteData("cancel", "<job id>", "Cancel a queued or running bulk job")
+	cancel.AddTextArgument("ID of the job to cancel", "<job id>", "")
+	command.AddCommand(cancel)
+
+	undo := model.NewAutocompleteData("undo", "<job id>", "Remove the users added by a finished bulk job")
+	undo.AddTextArgument("ID of the job to undo", "<job id>", "")
+	command.AddCommand(undo)
+
+	history := model.NewAutocompleteData("history", "", "List the last bulk jobs of the current channel")
+	command.AddCommand(history)
+
+	help := model.NewAutocompleteData("help", "", "Show help")
+	command.AddCommand(help)
+
+	return command
+}
+
+// Execute runs the slash command
+func (h *Handler) Execute(args *model.CommandArgs) *model.CommandResponse {
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return responsef(helpText)
+	}
+
+	switch fields[1] {
+	case "add":
+		return h.executeAdd(args, fields[2:])
+	case "add-file":
+		return h.executeAddFile(args, fields[2:])
+	case "copy":
+		return h.executeCopy(args, fields[2:])
+	case "status":
+		return h.executeStatus(args, fields[2:])
+	case "cancel":
+		return h.executeCancel(args, fields[2:])
+	case "undo":
+		return h.executeUndo(args, fields[2:])
+	case "history":
+		return h.executeHistory(args)
+	case "help":
+		return responsef(helpText)
+	default:
+		return responsef("Unknown command `%s`.\n\n%s", fields[1], helpText)
+	}
+}
+
+func responsef(format string, args ...any) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,155 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// historyJobs the number of jobs listed by the history command
+const historyJobs = 10
+
+// getJob loads a job the user can access
+func (h *Handler) getJob(userID, jobID string) (*engine.Job, *perror.PError) {
+	job, err := h.engine.GetJob(userID, jobID)
+	if err != nil {
+		if !errors.Is(err, kvstore.ErrNotFound) {
+			h.API.LogError("error getting job", "job_id", jobID, "err", err.Error())
+			return nil, perror.NewPError(err, fmt.Sprintf("Error getting job `%s`. Please check logs for more information.", jobID))
+		}
+		return nil, perror.NewPError(err, fmt.Sprintf("Job `%s` not found.", jobID))
+	}
+
+	return job, nil
+}
+
+// getChannelJobs returns the most recent jobs of the channel the user can access
+func (h *Handler) getChannelJobs(userID, channelID string, limit int) ([]*engine.Job, *perror.PError) {
+	opts := engine.JobListOptions{
+		ChannelID: channelID,
+		PerPage:   limit,
+	}
+	if !h.engine.IsSystemAdmin(userID) {
+		opts.UserID = userID
+	}
+
+	jobs, err := h.engine.ListJobs(opts)
+	if err != nil {
+		h.API.LogError("error listing jobs", "err", err.Error())
+		return nil, perror.NewPError(err, "Error listing jobs. Please check logs for more information.")
+	}
+
+	return jobs, nil
+}
+
+func (h *Handler) executeStatus(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) > 1 {
+		return responsef("Please provide a single job ID: `/bulk-invite status [job id]`")
+	}
+
+	if len(params) == 1 {
+		job, perr := h.getJob(args.UserId, params[0])
+		if perr != nil {
+			return responsef("%s", perr.Message())
+		}
+		return responsef("%s", jobStatusMessage(job))
+	}
+
+	jobs, perr := h.getChannelJobs(args.UserId, args.ChannelId, 1)
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+	if len(jobs) == 0 {
+		return responsef("There are no bulk jobs in this channel.")
+	}
+
+	return responsef("%s", jobStatusMessage(jobs[0]))
+}
+
+func (h *Handler) executeCancel(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return responsef("Please provide the ID of the job to cancel: `/bulk-invite cancel <job id>`")
+	}
+
+	job, perr := h.getJob(args.UserId, params[0])
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	if perr := h.engine.CancelJob(job); perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	return responsef("Cancellation of job `%s` requested.", job.ID)
+}
+
+func (h *Handler) executeUndo(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return responsef("Please provide the ID of the job to undo: `/bulk-invite undo <job id>`")
+	}
+
+	job, perr := h.getJob(args.UserId, params[0])
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	undoJob, perr := h.engine.UndoJob(context.Background(), job, args.UserId)
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+
+	return responsef("Removing the %d users added by job `%s`. Job ID: `%s`.", undoJob.TotalUsers, job.ID, undoJob.ID)
+}
+
+func (h *Handler) executeHistory(args *model.CommandArgs) *model.CommandResponse {
+	jobs, perr := h.getChannelJobs(args.UserId, args.ChannelId, historyJobs)
+	if perr != nil {
+		return responsef("%s", perr.Message())
+	}
+	if len(jobs) == 0 {
+		return responsef("There are no bulk jobs in this channel.")
+	}
+
+	message := fmt.Sprintf("Last %d bulk jobs of this channel:\n\n", len(jobs))
+	message += "| Job ID | Operation | State | Processed users | Created |\n"
+	message += "|:--|:--|:--|--:|:--|\n"
+	for _, job := range jobs {
+		message += fmt.Sprintf("| `%s` | %s | %s | %d/%d | %s |\n",
+			job.ID, job.Operation, job.State, job.ProcessedUsers, job.TotalUsers, formatTime(job.CreateAt))
+	}
+
+	return responsef("%s", message)
+}
+
+// jobStatusMessage formats the progress and the results of a job
+func jobStatusMessage(job *engine.Job) string {
+	message := fmt.Sprintf("Job `%s` (%s, created on %s) is **%s**: %d of %d users processed.\n",
+		job.ID, job.Operation, formatTime(job.CreateAt), job.State, job.ProcessedUsers, job.TotalUsers)
+
+	if job.Error != "" {
+		message += fmt.Sprintf("Error: %s\n", job.Error)
+	}
+
+	switch job.Operation {
+	case engine.OperationRemove:
+		return message + job.Result.PrettyRemoveString()
+	case engine.OperationUndo:
+		return message + job.Result.PrettyUndoString()
+	case engine.OperationSync:
+		if job.SyncDiff != nil {
+			message += fmt.Sprintf("Changes when the sync started: %s.\n", job.SyncDiff)
+		}
+		return message + job.Result.PrettySyncString()
+	}
+	return message + job.Result.PrettyString()
+}
+
+func formatTime(millis int64) string {
+	return time.UnixMilli(millis).UTC().Format("2006-01-02 15:04 MST")
+}
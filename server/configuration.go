@@ -2,6 +2,8 @@ package main
 
 import (
 	"reflect"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -16,6 +18,24 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
+	// Concurrency the number of users processed in parallel by each bulk job
+	Concurrency int
+
+	// RateLimit the maximum number of team and channel memberships created per second, 0 for no limit
+	RateLimit int
+
+	// UndoWindowHours the hours after a job finishes during which it can be undone, 0 disables undo
+	UndoWindowHours int
+
+	// ProgressUpdateInterval the minimum seconds between updates of the progress post of a running
+	// job, 0 to only update it when the job finishes
+	ProgressUpdateInterval int
+
+	// GuestPolicy how guests are treated by the operations that don't set a policy
+	GuestPolicy string
+
+	// AllowBots adds bot accounts to channels instead of skipping them
+	AllowBots bool
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -25,6 +45,19 @@ func (c *configuration) Clone() *configuration {
 	return &clone
 }
 
+// engineSettings returns the engine settings set in the configuration
+func (c *configuration) engineSettings() engine.Settings {
+	return engine.Settings{
+		Concurrency:     c.Concurrency,
+		RateLimit:       c.RateLimit,
+		UndoWindowHours: c.UndoWindowHours,
+
+		ProgressUpdateIntervalSeconds: c.ProgressUpdateInterval,
+		GuestPolicy:                   engine.GuestPolicy(c.GuestPolicy),
+		AllowBots:                     c.AllowBots,
+	}
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
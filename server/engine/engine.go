@@ -2,8 +2,11 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
@@ -11,26 +14,101 @@ import (
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
+const (
+	// jobSaveInterval the number of processed users between job progress updates in the store
+	jobSaveInterval = 50
+
+	// maxChannelsPerJob the maximum number of channels targeted by a single job
+	maxChannelsPerJob = 20
+
+	// finishedJobRetention how long finished jobs are kept in the store, extended to the undo window
+	// if it's longer
+	finishedJobRetention = 30 * 24 * time.Hour
+)
+
+// errJobCancelled the cancel cause of the jobs stopped by a user
+var errJobCancelled = errors.New("job cancelled")
+
+// Settings the engine settings exposed in the plugin configuration
+type Settings struct {
+	// Concurrency the number of users of a batch processed in parallel
+	Concurrency int
+
+	// RateLimit the maximum number of team and channel memberships created per second in this node,
+	// 0 for no limit
+	RateLimit int
+
+	// UndoWindowHours the hours after a job finishes during which it can be undone, 0 disables undo
+	UndoWindowHours int
+
+	// ProgressUpdateIntervalSeconds the minimum seconds between updates of the progress post of a
+	// running job, 0 to only update it when the job finishes
+	ProgressUpdateIntervalSeconds int
+
+	// GuestPolicy how guests are treated by the operations that don't set a policy, GuestPolicySkip
+	// if empty
+	GuestPolicy GuestPolicy
+
+	// AllowBots adds bot accounts to the channels like regular users, instead of skipping them
+	AllowBots bool
+}
+
 type Engine struct {
 	API plugin.API
 
 	lockStore kvstore.LockStore
 
+	jobStore JobStore
+
 	// botUserID the bot user ID to set when sending messages
 	botUserID string
 
+	// settings the current engine settings and the rate limiter shared by all the jobs of this node
+	settings     Settings
+	limiter      *rateLimiter
+	settingsLock sync.RWMutex
+
+	// runningJobs the cancel functions of the jobs running in this node, by job ID
+	runningJobs     map[string]context.CancelCauseFunc
+	runningJobsLock sync.Mutex
+	runningJobsWG   sync.WaitGroup
+
 	// onFinish is called when the bulk operation finishes. Mainly used for testing.
 	onFinish func()
 }
 
-func NewEngine(pluginAPI plugin.API, lockStore kvstore.LockStore, botUserID string) *Engine {
+func NewEngine(pluginAPI plugin.API, lockStore kvstore.LockStore, jobStore JobStore, botUserID string) *Engine {
 	return &Engine{
-		API:       pluginAPI,
-		lockStore: lockStore,
-		botUserID: botUserID,
+		API:         pluginAPI,
+		lockStore:   lockStore,
+		jobStore:    jobStore,
+		botUserID:   botUserID,
+		settings:    Settings{Concurrency: 1},
+		runningJobs: map[string]context.CancelCauseFunc{},
 	}
 }
 
+// SetSettings updates the engine settings, running jobs apply them from their next batch of users
+func (e *Engine) SetSettings(settings Settings) {
+	if settings.Concurrency < 1 {
+		settings.Concurrency = 1
+	}
+
+	e.settingsLock.Lock()
+	defer e.settingsLock.Unlock()
+
+	if settings.RateLimit != e.settings.RateLimit {
+		e.limiter = newRateLimiter(settings.RateLimit)
+	}
+	e.settings = settings
+}
+
+func (e *Engine) getSettings() (Settings, *rateLimiter) {
+	e.settingsLock.RLock()
+	defer e.settingsLock.RUnlock()
+	return e.settings, e.limiter
+}
+
 // SetOnFinish sets the function to be called when the bulk operation finishes. Mainly used for testing.
 func (e *Engine) SetOnFinish(f func()) {
 	e.onFinish = f
@@ -46,26 +124,86 @@ func (e *Engine) checkPermissionsForUser(config *Config) *perror.PError {
 	switch config.channel.Type {
 	case model.ChannelTypePrivate:
 		if !e.API.HasPermissionToChannel(config.UserID, config.ChannelID, model.PermissionManagePrivateChannelMembers) {
-			return perror.NewPError(fmt.Errorf("insufficient_private_channel_permissions__add_user"), "You dont have permission to add users to this channel")
+			return perror.NewPError(fmt.Errorf("insufficient_private_channel_permissions__%s_user", config.operationName()), fmt.Sprintf("You dont have permission to %s this channel", config.channelAction()))
 		}
 	case model.ChannelTypeOpen:
 		if !e.API.HasPermissionToChannel(config.UserID, config.ChannelID, model.PermissionManagePublicChannelMembers) {
-			return perror.NewPError(fmt.Errorf("insufficient_public_channel_permissions__add_user"), "You dont have permission to add users to this channel")
+			return perror.NewPError(fmt.Errorf("insufficient_public_channel_permissions__%s_user", config.operationName()), fmt.Sprintf("You dont have permission to %s this channel", config.channelAction()))
 		}
 	}
 
-	if config.AddToTeam && !e.API.HasPermissionToTeam(config.UserID, config.channel.TeamId, model.PermissionAddUserToTeam) {
+	if !config.isRemove() && config.AddToTeam && !e.API.HasPermissionToTeam(config.UserID, config.channel.TeamId, model.PermissionAddUserToTeam) {
 		return perror.NewPError(fmt.Errorf("insufficient_team_permissions__add_user"), "You dont have enough permissions to add users to this team")
 	}
 
+	if config.GuestPolicy == GuestPolicyAllowAndAddToTeam && !e.API.HasPermissionToTeam(config.UserID, config.channel.TeamId, model.PermissionInviteGuest) {
+		// Users that can't invite guests only add the guests already in the team by default
+		if !config.guestPolicyFromSettings {
+			return perror.NewPError(fmt.Errorf("insufficient_team_permissions__add_guest"), "You dont have enough permissions to add guests to this team")
+		}
+		config.GuestPolicy = GuestPolicyAllowTeamMembers
+	}
+
 	return nil
 }
 
-func (e *Engine) StartJob(ctx context.Context, config *Config) *perror.PError {
-	if e.lockStore.IsLocked(config.ChannelID) {
-		return perror.NewPError(fmt.Errorf("channel_locked"), "A bulk operation is already running on this channel. Please wait until it finishes.")
+// validateConfig loads the channels of the config and checks that the user can run bulk operations
+// on them
+func (e *Engine) validateConfig(config *Config) *perror.PError {
+	switch config.Operation {
+	case "":
+		config.Operation = OperationAdd
+	case OperationAdd:
+	case OperationRemove:
+		config.AddToTeam = false
+	case OperationSync:
+	case OperationUndo:
+		config.AddToTeam = false
+	default:
+		return perror.NewPError(fmt.Errorf("invalid operation %s", config.Operation), "Invalid bulk operation")
+	}
+	if !config.isSync() {
+		config.RemoveExtras = false
+	}
+	if err := e.resolveGuestPolicy(config); err != nil {
+		return err
+	}
+
+	config.normalizeChannels()
+	if len(config.ChannelIDs) > maxChannelsPerJob {
+		return perror.NewPError(
+			fmt.Errorf("too many channels"),
+			fmt.Sprintf("A bulk operation can target up to %d channels", maxChannelsPerJob),
+		)
+	}
+
+	// The list is the final membership of a single channel
+	if config.isSync() && config.isMultiChannel() {
+		return perror.NewPError(fmt.Errorf("sync multiple channels"), "A sync can only target one channel")
+	}
+
+	config.channels = make([]*model.Channel, 0, len(config.ChannelIDs))
+	for i := range config.ChannelIDs {
+		channelConfig := config.channelConfig(i)
+		if err := e.validateChannel(channelConfig); err != nil {
+			return err
+		}
+		// The guest policy may be lowered by the permissions of the user in the channel team
+		config.GuestPolicy = channelConfig.GuestPolicy
+		config.channels = append(config.channels, channelConfig.channel)
+	}
+	config.channel = config.channels[0]
+
+	if config.isUndo() && config.undo == nil {
+		return e.loadUndoRecords(config)
 	}
 
+	return nil
+}
+
+// validateChannel loads the channel of a single channel config and checks that the user can run bulk
+// operations on it
+func (e *Engine) validateChannel(config *Config) *perror.PError {
 	var appErr *model.AppError
 	config.channel, appErr = e.API.GetChannel(config.ChannelID)
 	if appErr != nil {
@@ -84,29 +222,373 @@ func (e *Engine) StartJob(ctx context.Context, config *Config) *perror.PError {
 		)
 	}
 
+	// Users can't leave the default channels of the team
+	if (config.isRemove() || config.RemoveExtras) && e.isDefaultChannel(config.channel) {
+		return perror.NewPError(
+			fmt.Errorf("default_channel_remove_not_supported"),
+			fmt.Sprintf("Users can't be removed from `%s` since it's a default channel of the team", config.channel.DisplayName),
+		)
+	}
+
 	if err := e.checkPermissionsForUser(config); err != nil {
 		return perror.NewPError(
 			fmt.Errorf("insufficient permissions: %w", err),
-			"Insufficient permissions to add users to channel",
+			fmt.Sprintf("Insufficient permissions to %s channel `%s`", config.channelAction(), config.channel.DisplayName),
 		)
 	}
 
-	if err := e.lockStore.Lock(config.ChannelID); err != nil {
-		return perror.NewInternalServerPError(
+	return nil
+}
+
+// isDefaultChannel returns true for the town square and the channels every team member joins
+func (e *Engine) isDefaultChannel(channel *model.Channel) bool {
+	if channel.Name == model.DefaultChannelName {
+		return true
+	}
+
+	serverConfig := e.API.GetConfig()
+	if serverConfig == nil {
+		return false
+	}
+
+	for _, name := range serverConfig.TeamSettings.ExperimentalDefaultChannels {
+		if channel.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *Engine) StartJob(ctx context.Context, config *Config) (*Job, *perror.PError) {
+	config.normalizeChannels()
+	for _, channelID := range config.ChannelIDs {
+		if e.lockStore.IsLocked(channelID) {
+			message := "A bulk operation is already running on this channel. Please wait until it finishes."
+			if config.isMultiChannel() {
+				message = fmt.Sprintf("A bulk operation is already running on channel `%s`. Please wait until it finishes.", channelID)
+			}
+			return nil, perror.NewPError(fmt.Errorf("channel_locked"), message)
+		}
+	}
+
+	if err := e.validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	if err := e.loadSourceUsers(config); err != nil {
+		return nil, err
+	}
+
+	job := newJob(config)
+	if err := e.lockChannels(config.ChannelIDs, job.ID); err != nil {
+		if errors.Is(err, kvstore.ErrIsLocked) {
+			return nil, perror.NewPError(err, "A bulk operation is already running on this channel. Please wait until it finishes.")
+		}
+		return nil, perror.NewInternalServerPError(
 			fmt.Errorf("error locking channel: %w", err),
 		)
 	}
 
-	go e.start(ctx, config)
+	if err := e.jobStore.SaveJobInput(job.ID, config.Users); err != nil {
+		e.API.LogError("error storing job input", "channel_id", config.ChannelID, "err", err.Error())
+		e.unlockChannels(config.ChannelIDs, job.ID)
+		return nil, perror.NewInternalServerPError(
+			fmt.Errorf("error storing job input: %w", err),
+		)
+	}
+
+	if err := e.jobStore.CreateJob(job); err != nil {
+		e.API.LogError("error storing job", "channel_id", config.ChannelID, "err", err.Error())
+		e.unlockChannels(config.ChannelIDs, job.ID)
+		return nil, perror.NewInternalServerPError(
+			fmt.Errorf("error storing job: %w", err),
+		)
+	}
+
+	// Return a copy since the job is updated concurrently while running
+	jobCopy := *job
+
+	e.run(ctx, config, job)
+
+	return &jobCopy, nil
+}
+
+// lockChannels locks all the channels of a job, none of them are locked if any fails. The job owns
+// the locks, so only the job can release them.
+func (e *Engine) lockChannels(channelIDs []string, jobID string) error {
+	for i, channelID := range channelIDs {
+		if err := e.lockStore.Lock(channelID, jobID); err != nil {
+			e.unlockChannels(channelIDs[:i], jobID)
+			return fmt.Errorf("error locking channel %s: %w", channelID, err)
+		}
+	}
 
 	return nil
 }
 
-func (e *Engine) start(_ context.Context, config *Config) {
-	defer func() {
-		if err := e.lockStore.Unlock(config.ChannelID); err != nil {
-			e.API.LogError("error unlocking channel. channel will be automatically unlocked after ttl expired", "channel_id", config.ChannelID, "err", err.Error())
+func (e *Engine) unlockChannels(channelIDs []string, jobID string) {
+	for _, channelID := range channelIDs {
+		if err := e.lockStore.Unlock(channelID, jobID); err != nil {
+			e.API.LogError("error unlocking channel. channel will be automatically unlocked after ttl expired", "channel_id", channelID, "err", err.Error())
 		}
+	}
+}
+
+// heartbeatLocks renews the channel locks of a running job until the returned function is called,
+// so jobs running longer than the lock TTL keep their channels locked. The job is interrupted if
+// another job took one of its channels, errors of the store are retried on the next renewal.
+func (e *Engine) heartbeatLocks(channelIDs []string, jobID string, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, channelID := range channelIDs {
+					err := e.lockStore.Renew(channelID, jobID)
+					if errors.Is(err, kvstore.ErrNotLockOwner) || errors.Is(err, kvstore.ErrIsLocked) {
+						e.API.LogError("channel lock taken by another job, interrupting job", "job_id", jobID, "channel_id", channelID, "err", err.Error())
+						e.cancelRunningJob(jobID, errJobInterrupted)
+						return
+					}
+					if err != nil {
+						e.API.LogWarn("error renewing channel lock", "job_id", jobID, "channel_id", channelID, "err", err.Error())
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// GetChannelLock returns the metadata of the lock of a channel, kvstore.ErrNotFound if it's not locked
+func (e *Engine) GetChannelLock(channelID string) (*kvstore.LockInfo, error) {
+	return e.lockStore.GetLock(channelID)
+}
+
+// ReleaseStaleChannelLock releases the lock of a channel held by a job that stopped renewing it,
+// like the jobs of a crashed node
+func (e *Engine) ReleaseStaleChannelLock(channelID string) error {
+	return e.lockStore.ReleaseStale(channelID)
+}
+
+// run starts processing the job in the background
+func (e *Engine) run(ctx context.Context, config *Config, job *Job) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	e.addRunningJob(job.ID, cancel)
+
+	e.runningJobsWG.Add(1)
+	go func() {
+		defer e.runningJobsWG.Done()
+		e.start(jobCtx, config, job)
+	}()
+}
+
+// DryRun walks the same path as a bulk job for every user without adding them to or removing them
+// from the channel or the team, returning the predicted outcome for each user.
+func (e *Engine) DryRun(config *Config) (*DryRunResult, *perror.PError) {
+	config.DryRun = true
+
+	if err := e.validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	if err := e.loadSourceUsers(config); err != nil {
+		return nil, err
+	}
+
+	if err := e.loadSyncDiff(config); err != nil {
+		return nil, err
+	}
+
+	dryRun := &DryRunResult{
+		Users:    make([]UserResult, 0, len(config.Users)*len(config.ChannelIDs)),
+		SyncDiff: config.syncDiff,
+	}
+	if config.isMultiChannel() {
+		dryRun.ChannelResults = map[string]bulkChannelAddResult{}
+	}
+
+	for channelIndex := range config.ChannelIDs {
+		channelConfig := config.channelConfig(channelIndex)
+		e.prefetchTeamUsers(channelConfig, len(config.Users))
+
+		for i := 0; i < len(config.Users); i += jobSaveInterval {
+			end := i + jobSaveInterval
+			if end > len(config.Users) {
+				end = len(config.Users)
+			}
+
+			for _, userResult := range e.processUsers(context.Background(), channelConfig, config.Users[i:end]) {
+				if config.isMultiChannel() {
+					userResult.ChannelID = channelConfig.ChannelID
+					channelResult := dryRun.ChannelResults[channelConfig.ChannelID]
+					channelResult.add(userResult)
+					dryRun.ChannelResults[channelConfig.ChannelID] = channelResult
+				}
+				dryRun.Result.add(userResult)
+				dryRun.Users = append(dryRun.Users, userResult)
+			}
+		}
+	}
+
+	return dryRun, nil
+}
+
+// GetJob returns the stored job with the provided ID if the user can access it. Jobs the user can't
+// access are reported as not found, so their existence is not disclosed.
+func (e *Engine) GetJob(userID, jobID string) (*Job, error) {
+	job, err := e.jobStore.GetJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.canUserAccessJob(userID, job) {
+		return nil, kvstore.ErrNotFound
+	}
+
+	return job, nil
+}
+
+// ListJobs returns a page of the stored jobs matching the options, most recent first
+func (e *Engine) ListJobs(opts JobListOptions) ([]*Job, error) {
+	return e.jobStore.ListJobs(opts)
+}
+
+// IsSystemAdmin returns true if the user can manage the system
+func (e *Engine) IsSystemAdmin(userID string) bool {
+	return e.API.HasPermissionTo(userID, model.PermissionManageSystem)
+}
+
+// canUserAccessJob returns true if the user triggered the job or is a system admin
+func (e *Engine) canUserAccessJob(userID string, job *Job) bool {
+	return job.UserID == userID || e.IsSystemAdmin(userID)
+}
+
+// CancelJob stops a queued or running job. If the job is running in another node, the cancellation is
+// requested through the store and applied by the node running it.
+func (e *Engine) CancelJob(job *Job) *perror.PError {
+	if job.IsFinished() {
+		return perror.NewPError(fmt.Errorf("job_finished"), fmt.Sprintf("Job `%s` has already finished.", job.ID))
+	}
+
+	if e.cancelRunningJob(job.ID, errJobCancelled) {
+		return nil
+	}
+
+	// Interrupted jobs are not running anywhere, claim them to prevent them from being resumed
+	if job.State == JobStateInterrupted {
+		claimed, err := e.jobStore.ClaimJob(job)
+		if err != nil {
+			return perror.NewInternalServerPError(fmt.Errorf("error claiming interrupted job: %w", err))
+		}
+		if !claimed {
+			return perror.NewPError(fmt.Errorf("job_claimed"), fmt.Sprintf("Job `%s` is being resumed, please try again.", job.ID))
+		}
+
+		job.State = JobStateCancelled
+		job.FinishAt = model.GetMillis()
+		e.saveJob(job)
+		e.deleteJobInput(job.ID)
+		return nil
+	}
+
+	if err := e.jobStore.RequestJobCancel(job.ID); err != nil {
+		return perror.NewInternalServerPError(fmt.Errorf("error requesting job cancel: %w", err))
+	}
+
+	return nil
+}
+
+func (e *Engine) addRunningJob(jobID string, cancel context.CancelCauseFunc) {
+	e.runningJobsLock.Lock()
+	defer e.runningJobsLock.Unlock()
+	e.runningJobs[jobID] = cancel
+}
+
+func (e *Engine) removeRunningJob(jobID string) {
+	e.runningJobsLock.Lock()
+	defer e.runningJobsLock.Unlock()
+	if cancel, ok := e.runningJobs[jobID]; ok {
+		cancel(nil)
+		delete(e.runningJobs, jobID)
+	}
+}
+
+// cancelRunningJob cancels the context of a job running in this node, returns false if the job
+// isn't running in this node.
+func (e *Engine) cancelRunningJob(jobID string, cause error) bool {
+	e.runningJobsLock.Lock()
+	defer e.runningJobsLock.Unlock()
+	cancel, ok := e.runningJobs[jobID]
+	if ok {
+		cancel(cause)
+	}
+	return ok
+}
+
+// checkCancelRequested cancels the job if a cancellation was requested from another node
+func (e *Engine) checkCancelRequested(jobID string) {
+	requested, err := e.jobStore.IsJobCancelRequested(jobID)
+	if err != nil {
+		e.API.LogError("error checking job cancel request", "job_id", jobID, "err", err.Error())
+		return
+	}
+
+	if requested {
+		e.cancelRunningJob(jobID, errJobCancelled)
+	}
+}
+
+func (e *Engine) saveJob(job *Job) {
+	job.UpdateAt = model.GetMillis()
+	if err := e.jobStore.SaveJob(job, e.finishedJobTTL()); err != nil {
+		e.API.LogError("error storing job", "job_id", job.ID, "channel_id", job.ChannelID, "err", err.Error())
+	}
+}
+
+// finishedJobTTL returns how long finished jobs are kept, at least during the undo window
+func (e *Engine) finishedJobTTL() time.Duration {
+	settings, _ := e.getSettings()
+	if undoWindow := time.Duration(settings.UndoWindowHours) * time.Hour; undoWindow > finishedJobRetention {
+		return undoWindow
+	}
+	return finishedJobRetention
+}
+
+func (e *Engine) failJob(job *Job, err error) {
+	job.State = JobStateFailed
+	job.Error = err.Error()
+	job.FinishAt = model.GetMillis()
+	e.saveJob(job)
+	e.deleteJobInput(job.ID)
+}
+
+// deleteJobInput removes the input of a job that won't be resumed
+func (e *Engine) deleteJobInput(jobID string) {
+	if err := e.jobStore.DeleteJobInput(jobID); err != nil {
+		e.API.LogError("error deleting job input", "job_id", jobID, "err", err.Error())
+	}
+}
+
+func (e *Engine) start(ctx context.Context, config *Config, job *Job) {
+	stopHeartbeat := e.heartbeatLocks(config.ChannelIDs, job.ID, kvstore.LockHeartbeatInterval)
+	defer func() {
+		stopHeartbeat()
+		e.removeRunningJob(job.ID)
+		e.unlockChannels(config.ChannelIDs, job.ID)
 
 		if e.onFinish != nil {
 			e.onFinish()
@@ -117,131 +599,471 @@ func (e *Engine) start(_ context.Context, config *Config) {
 	user, appErr := e.API.GetUser(config.UserID)
 	if appErr != nil {
 		e.API.LogError("error getting user information", "user_id", config.UserID, "err", appErr.Error())
+		e.failJob(job, appErr)
+		e.publishJobEvent(job, WebSocketEventJobFinished, newJobEvent(job))
 		e.onError(config, appErr)
 		return
 	}
 
-	if _, appErr = e.API.CreatePost(&model.Post{
-		ChannelId: config.channel.Id,
-		UserId:    e.botUserID,
-		Message:   fmt.Sprintf("Starting bulk add of %d users (triggered by @%s)", len(config.Users), user.Username),
-	}); appErr != nil {
-		e.API.LogError("error creating initial post in channel", "channel_id", config.ChannelID, "err", appErr.Error())
+	// The channel members are compared with the list once the job runs, since large channels take a
+	// while to page through
+	if config.isSync() && job.StartAt == 0 {
+		if err := e.loadJobSyncDiff(config, job); err != nil {
+			e.failJob(job, err)
+			e.publishJobEvent(job, WebSocketEventJobFinished, newJobEvent(job))
+			e.onError(config, err)
+			return
+		}
 	}
 
-	result := e.addUsersToChannel(config)
+	message := fmt.Sprintf("Starting bulk %s of %d users (triggered by @%s)", config.operationName(), len(config.Users), user.Username)
+	if config.isMultiChannel() {
+		message = fmt.Sprintf("Starting bulk %s of %d users in %d channels (triggered by @%s)", config.operationName(), len(config.Users), len(config.ChannelIDs), user.Username)
+	}
+	if config.isSync() && config.syncDiff != nil {
+		message = fmt.Sprintf("Starting bulk sync of the channel members (triggered by @%s): %s.", user.Username, config.syncDiff)
+	}
+	if job.StartAt != 0 {
+		message = fmt.Sprintf("Resuming bulk %s of %d remaining users (triggered by @%s)", config.operationName(), job.TotalUsers-job.ProcessedUsers, user.Username)
+		if config.isMultiChannel() {
+			message = fmt.Sprintf("Resuming bulk %s of %d users in %d channels from where it was interrupted (triggered by @%s)", config.operationName(), len(config.Users), len(config.ChannelIDs), user.Username)
+		}
+	} else {
+		job.StartAt = model.GetMillis()
+	}
 
-	post, appErr := e.API.CreatePost(&model.Post{
-		ChannelId: config.ChannelID,
-		UserId:    e.botUserID,
-		Message:   "Bulk add process finished.",
-	})
-	if appErr != nil {
-		e.API.LogError("error creating result post in channel", "channel_id", config.ChannelID, "err", appErr.Error())
-		e.onError(config, appErr)
+	progress := e.newProgressTracker(config, job, message)
+	job.State = JobStateRunning
+	e.saveJob(job)
+	e.publishJobEvent(job, WebSocketEventJobStarted, newJobEvent(job))
+
+	var report Report
+	if job.ProcessedUsers > 0 {
+		report = e.loadReport(job, config)
+	}
+
+	report = e.processJobUsers(ctx, config, job, report, progress)
+
+	if errors.Is(context.Cause(ctx), errJobInterrupted) {
+		// Keep the input to resume the job from the last processed user
+		e.API.LogInfo("bulk job interrupted", "job_id", job.ID, "channel_id", config.ChannelID, "processed_users", job.ProcessedUsers)
+		job.State = JobStateInterrupted
+		e.saveJob(job)
+		e.publishJobEvent(job, WebSocketEventJobProgress, newJobEvent(job))
+		return
+	}
+
+	message = fmt.Sprintf("Bulk %s process finished.", config.operationName())
+	job.State = JobStateFinished
+	if ctx.Err() != nil {
+		e.API.LogInfo("bulk job cancelled", "job_id", job.ID, "channel_id", config.ChannelID, "processed_users", job.ProcessedUsers)
+		message = fmt.Sprintf("Bulk %s process cancelled after processing %d of %d users.", config.operationName(), job.ProcessedUsers, job.TotalUsers)
+		if config.isMultiChannel() {
+			message = fmt.Sprintf("Bulk %s process cancelled after processing %d of %d users across all channels.", config.operationName(), job.ProcessedUsers, job.TotalUsers)
+		}
+		job.State = JobStateCancelled
 	}
+	job.FinishAt = model.GetMillis()
+	e.saveJob(job)
+	e.deleteJobInput(job.ID)
+	e.publishJobEvent(job, WebSocketEventJobFinished, newJobEvent(job))
 
-	if _, err := e.API.CreatePost(&model.Post{
+	fileIDs := e.uploadReport(job, report)
+
+	// Edited posts can't get new files, so the reports are attached to a reply
+	if progress.finish(message) {
+		if len(fileIDs) == 0 {
+			return
+		}
+
+		if _, appErr := e.API.CreatePost(&model.Post{
+			ChannelId: config.ChannelID,
+			UserId:    e.botUserID,
+			RootId:    progress.post.Id,
+			Message:   "Per-user report of the bulk operation.",
+			FileIds:   fileIDs,
+		}); appErr != nil {
+			e.API.LogError("error creating report post in channel", "channel_id", config.ChannelID, "err", appErr.Error())
+			e.onError(config, appErr)
+		}
+		return
+	}
+
+	if _, appErr := e.API.CreatePost(&model.Post{
 		ChannelId: config.ChannelID,
 		UserId:    e.botUserID,
-		RootId:    post.Id,
-		Message:   result.PrettyString(),
-	}); err != nil {
-		e.API.LogError("error creating threaded result post in channel", "channel_id", config.ChannelID, "err", err.Error())
-		e.onError(config, err)
+		Message:   fmt.Sprintf("%s\n\n%s", message, resultMessage(config, job)),
+		FileIds:   fileIDs,
+	}); appErr != nil {
+		e.API.LogError("error creating result post in channel", "channel_id", config.ChannelID, "err", appErr.Error())
+		e.onError(config, appErr)
 	}
 }
 
-func (e *Engine) addUserToChannelByUserID(config *Config, u AddUser, result *bulkChannelAddResult) error {
-	if err := e.addToChannel(u.UserID, config, result); err != nil {
-		return fmt.Errorf("error inviting user by ID: %w", err)
-	}
+// processUsers resolves a batch of users and adds them to or removes them from the channel, or only
+// checks their outcome on dry runs. Users are processed in parallel by the configured number of workers. Stops early if the
+// context is cancelled, returning the results of the processed users.
+func (e *Engine) processUsers(ctx context.Context, config *Config, users []AddUser) []UserResult {
+	settings, limiter := e.getSettings()
 
-	return nil
-}
+	resolved := e.resolveUsers(config, users)
+	channelMembers := e.getChannelMembers(config, resolved)
 
-func (e *Engine) addUserToChannelByUsername(config *Config, u AddUser, result *bulkChannelAddResult) error {
-	user, appErr := e.API.GetUserByUsername(u.Username)
-	if appErr != nil {
-		e.API.LogError("error getting user by username", "username", u.Username, "user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
-		result.errorUsers++
-		return fmt.Errorf("error getting user by username: %w", appErr)
+	results := make([]UserResult, len(users))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < settings.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				switch {
+				case resolved[i].result != nil:
+					results[i] = *resolved[i].result
+				case config.isUndo():
+					results[i] = e.undoUser(ctx, config, resolved[i].user, channelMembers, limiter)
+				case config.isRemove(), config.isSync() && users[i].Remove:
+					results[i] = e.removeFromChannel(ctx, config, resolved[i].user, channelMembers, limiter)
+				default:
+					results[i] = e.addToChannel(ctx, config, resolved[i].user, channelMembers, limiter)
+				}
+				results[i].Input = users[i].Identifier()
+			}
+		}()
 	}
 
-	if err := e.addToChannel(user.Id, config, result); err != nil {
-		return fmt.Errorf("error inviting user by username: %w", err)
+	// Users are dispatched in order and the ones already dispatched are always completed, so the
+	// processed users are the first ones of the batch even if the job is cancelled.
+	processed := 0
+dispatch:
+	for processed < len(users) && ctx.Err() == nil {
+		select {
+		case indexes <- processed:
+			processed++
+		case <-ctx.Done():
+			break dispatch
+		}
 	}
+	close(indexes)
+	wg.Wait()
 
-	return nil
+	// Users interrupted while waiting for the rate limiter, and the ones after them, are processed
+	// again if the job is resumed
+	for i := range results[:processed] {
+		if results[i].interrupted {
+			processed = i
+			break
+		}
+	}
+
+	return results[:processed]
 }
 
-func (e *Engine) addToChannel(userID string, config *Config, result *bulkChannelAddResult) error {
-	// Get user
-	user, appErr := e.API.GetUser(userID)
-	if appErr != nil {
-		e.API.LogError("error getting user information", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
-		result.errorUsers++
-		return appErr
+// addToChannel adds a resolved user to the channel. channelMembers holds the users of the batch that
+// are already members of the channel, nil if it couldn't be checked. Memberships are created at the
+// pace allowed by the limiter.
+func (e *Engine) addToChannel(ctx context.Context, config *Config, user *model.User, channelMembers map[string]bool, limiter *rateLimiter) UserResult {
+	userID := user.Id
+	result := UserResult{UserID: userID}
+
+	if outcome, skip := e.skippedUserOutcome(user); skip {
+		e.API.LogInfo("not adding user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "outcome", string(outcome))
+		result.Outcome = outcome
+		return result
 	}
 
-	// Check if user is guest
+	// Guests are added to the team depending on the guest policy only
+	addToTeam := config.AddToTeam
 	if user.IsGuest() {
-		e.API.LogInfo("not inviting guest user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID)
-		result.notAddedGuest++
-		return nil
+		switch config.GuestPolicy {
+		case GuestPolicyAllowTeamMembers:
+			addToTeam = false
+		case GuestPolicyAllowAndAddToTeam:
+			addToTeam = true
+		default:
+			e.API.LogInfo("not inviting guest user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID)
+			result.Outcome = OutcomeNotAddedGuest
+			return result
+		}
 	}
 
-	// Check team membership
-	teamMembership, appErr := e.API.GetTeamMember(config.channel.TeamId, userID)
-	if appErr != nil && appErr.StatusCode != http.StatusNotFound {
-		e.API.LogError("error getting team membership for user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId, "err", appErr.Error())
-		result.errorUsers++
-		return appErr
-	}
+	// Channel members always belong to the team
+	if !channelMembers[userID] {
+		isTeamMember, err := e.isTeamMember(config, userID)
+		if err != nil {
+			e.API.LogError("error getting team membership for user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId, "err", err.Error())
+			return newErrorUserResult(userID, fmt.Errorf("error getting team membership: %w", err))
+		}
 
-	if teamMembership == nil {
-		if config.AddToTeam {
-			if _, createAppErr := e.API.CreateTeamMember(config.channel.TeamId, userID); createAppErr != nil {
-				e.API.LogError("error creating team membership for user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId, "err", createAppErr.Error())
-				result.errorUsers++
-				return createAppErr
+		if !isTeamMember {
+			if !addToTeam && user.IsGuest() {
+				e.API.LogInfo("not inviting guest user since it doesn't belong to the team", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId)
+				result.Outcome = OutcomeNotAddedGuest
+				return result
 			}
-			result.addedToTeam++
-		} else {
-			e.API.LogInfo("not inviting member since it doesn't belong to the team", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId)
-			result.notAddedNonTeamMember++
-			return nil
+
+			if !addToTeam {
+				e.API.LogInfo("not inviting member since it doesn't belong to the team", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId)
+				result.Outcome = OutcomeNotAddedNonTeamMember
+				return result
+			}
+
+			if !config.DryRun {
+				if limiter.Wait(ctx) != nil {
+					return newInterruptedUserResult(userID)
+				}
+				if _, createAppErr := e.API.CreateTeamMember(config.channel.TeamId, userID); createAppErr != nil {
+					e.API.LogError("error creating team membership for user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId, "err", createAppErr.Error())
+					return newErrorUserResult(userID, fmt.Errorf("error adding user to team: %w", createAppErr))
+				}
+			}
+			result.AddedToTeam = true
 		}
 	}
 
+	// Existing members are reported apart, so running a job again doesn't count them as added
+	if channelMembers == nil {
+		result = e.checkChannelMembership(userID, config, result)
+		if result.Outcome != OutcomeAdded {
+			return result
+		}
+	} else if channelMembers[userID] {
+		result.Outcome = OutcomeAlreadyMember
+		return result
+	}
+
+	if config.DryRun {
+		result.Outcome = OutcomeAdded
+		return result
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		// The user may not be a team member anymore when the job is resumed, report it instead
+		if result.AddedToTeam {
+			errorResult := newErrorUserResult(userID, fmt.Errorf("job stopped before adding the user to the channel: %w", err))
+			errorResult.AddedToTeam = true
+			return errorResult
+		}
+		return newInterruptedUserResult(userID)
+	}
 	if _, appErr := e.API.AddUserToChannel(config.ChannelID, userID, config.UserID); appErr != nil {
-		result.errorUsers++
 		e.API.LogError("error adding user to channel", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
-		return appErr
+		errorResult := newErrorUserResult(userID, fmt.Errorf("error adding user to channel: %w", appErr))
+		errorResult.AddedToTeam = result.AddedToTeam
+		return errorResult
 	}
 
-	return nil
+	result.Outcome = OutcomeAdded
+	return result
 }
 
-func (e *Engine) addUsersToChannel(config *Config) bulkChannelAddResult {
-	var result bulkChannelAddResult
-	for _, u := range config.Users {
-		if u.UserID != "" {
-			if err := e.addUserToChannelByUserID(config, u, &result); err != nil {
-				continue
-			}
-			result.addedUsers++
-			continue
+// skippedUserOutcome returns the outcome of the users that are never added to channels: deactivated
+// users, bots unless allowed in the settings, and users of remote clusters synchronized by shared channels
+func (e *Engine) skippedUserOutcome(user *model.User) (UserOutcome, bool) {
+	switch {
+	case user.DeleteAt != 0:
+		return OutcomeNotAddedDeactivated, true
+	case user.IsRemote():
+		return OutcomeNotAddedRemote, true
+	case user.IsBot:
+		if settings, _ := e.getSettings(); !settings.AllowBots {
+			return OutcomeNotAddedBot, true
 		}
+	}
+
+	return "", false
+}
+
+// removeFromChannel removes a resolved user from the channel. channelMembers holds the users of the
+// batch that are members of the channel, nil if it couldn't be checked.
+func (e *Engine) removeFromChannel(ctx context.Context, config *Config, user *model.User, channelMembers map[string]bool, limiter *rateLimiter) UserResult {
+	result := UserResult{UserID: user.Id}
 
-		if u.Username != "" {
-			if err := e.addUserToChannelByUsername(config, u, &result); err != nil {
-				continue
+	if channelMembers != nil && !channelMembers[user.Id] {
+		result.Outcome = OutcomeNotMember
+		return result
+	}
+
+	if config.DryRun {
+		if channelMembers == nil {
+			result = e.checkChannelMembership(user.Id, config, result)
+			switch result.Outcome {
+			case OutcomeAdded:
+				result.Outcome = OutcomeNotMember
+			case OutcomeAlreadyMember:
+				result.Outcome = OutcomeRemoved
 			}
-			result.addedUsers++
-			continue
+			return result
+		}
+
+		result.Outcome = OutcomeRemoved
+		return result
+	}
+
+	if limiter.Wait(ctx) != nil {
+		return newInterruptedUserResult(user.Id)
+	}
+	if appErr := e.API.DeleteChannelMember(config.ChannelID, user.Id); appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			result.Outcome = OutcomeNotMember
+			return result
+		}
+		e.API.LogError("error removing user from channel", "remove_user_id", user.Id, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+		return newErrorUserResult(user.Id, fmt.Errorf("error removing user from channel: %w", appErr))
+	}
+
+	result.Outcome = OutcomeRemoved
+	return result
+}
+
+// checkChannelMembership predicts the outcome of adding a user to the channel without adding it
+func (e *Engine) checkChannelMembership(userID string, config *Config, result UserResult) UserResult {
+	result.Outcome = OutcomeAdded
+
+	// Users just added to the team can't be channel members
+	if result.AddedToTeam {
+		return result
+	}
+
+	if _, appErr := e.API.GetChannelMember(config.ChannelID, userID); appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return result
 		}
+		e.API.LogError("error getting channel membership for user", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+		return newErrorUserResult(userID, fmt.Errorf("error getting channel membership: %w", appErr))
 	}
 
+	result.Outcome = OutcomeAlreadyMember
 	return result
 }
+
+// processJobUsers applies the operation to the users, starting after the last processed user of the job,
+// and updates the job results.
+// Jobs targeting multiple channels process every user in the first channel, then in the next one.
+// Users are processed in batches of jobSaveInterval users, so a resumed job may process again some users.
+// The progress post is updated after each batch.
+func (e *Engine) processJobUsers(ctx context.Context, config *Config, job *Job, report Report, progress *progressTracker) Report {
+	result := job.Result
+	channelResults := map[string]bulkChannelAddResult{}
+	for channelID, channelResult := range job.ChannelResults {
+		channelResults[channelID] = channelResult
+	}
+
+	userCount := len(config.Users)
+	total := userCount * len(config.ChannelIDs)
+
+	// Channels of the same team share the prefetched team users
+	teamUsers := map[string]map[string]*model.User{}
+
+	start := job.ProcessedUsers
+	savedUsers := start
+	var channelConfig *Config
+	for i := start; i < total; {
+		if i > start && i%jobSaveInterval == 0 {
+			job.ProcessedUsers = i
+			job.Result = result
+			job.ChannelResults = channelResults
+			e.saveReport(job.ID, report, savedUsers)
+			savedUsers = i
+			e.saveJob(job)
+			e.checkCancelRequested(job.ID)
+			e.publishJobEvent(job, WebSocketEventJobProgress, newJobEvent(job))
+			progress.update()
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		channelIndex := i / userCount
+		userIndex := i % userCount
+		if channelConfig == nil || channelConfig.ChannelID != config.ChannelIDs[channelIndex] {
+			channelConfig = config.channelConfig(channelIndex)
+			if users, ok := teamUsers[channelConfig.channel.TeamId]; ok {
+				channelConfig.teamUsers = users
+			} else {
+				e.prefetchTeamUsers(channelConfig, userCount-userIndex)
+				teamUsers[channelConfig.channel.TeamId] = channelConfig.teamUsers
+			}
+		}
+
+		// Batches end at the next progress update or at the end of the channel
+		end := (i/jobSaveInterval + 1) * jobSaveInterval
+		if channelEnd := (channelIndex + 1) * userCount; end > channelEnd {
+			end = channelEnd
+		}
+
+		userResults := e.processUsers(ctx, channelConfig, config.Users[userIndex:userIndex+end-i])
+		for j := range userResults {
+			result.add(userResults[j])
+
+			if config.isMultiChannel() {
+				userResults[j].ChannelID = channelConfig.ChannelID
+				channelResult := channelResults[channelConfig.ChannelID]
+				channelResult.add(userResults[j])
+				channelResults[channelConfig.ChannelID] = channelResult
+			}
+
+			if userResults[j].Outcome == OutcomeError {
+				event := newJobEvent(job)
+				event.ProcessedUsers = i + j + 1
+				event.Result = result
+				event.User = &userResults[j]
+				e.publishJobEvent(job, WebSocketEventJobUserFailed, event)
+			}
+		}
+		report = append(report, userResults...)
+		i += len(userResults)
+	}
+
+	job.ProcessedUsers = len(report)
+	job.Result = result
+	job.ChannelResults = channelResults
+	e.saveReport(job.ID, report, savedUsers)
+
+	return report
+}
+
+// resultMessage formats the result of a job for the channel thread, with a summary of every channel
+// on jobs targeting multiple channels
+func resultMessage(config *Config, job *Job) string {
+	message := job.Result.PrettyString()
+	switch {
+	case config.isRemove():
+		message = job.Result.PrettyRemoveString()
+	case config.isUndo():
+		message = job.Result.PrettyUndoString()
+	case config.isSync():
+		message = job.Result.PrettySyncString()
+		if job.SyncDiff != nil {
+			message += fmt.Sprintf("- **Members not in the list kept**: %d\n", job.SyncDiff.Kept)
+		}
+	}
+
+	if !config.isMultiChannel() {
+		return message
+	}
+
+	if config.isRemove() || config.isUndo() {
+		message += "\n| Channel | Removed | Not removed | Errors |\n|:--|--:|--:|--:|\n"
+	} else {
+		message += "\n| Channel | Added | Not added | Errors |\n|:--|--:|--:|--:|\n"
+	}
+
+	for i, channelID := range config.ChannelIDs {
+		name := channelID
+		if i < len(config.channels) {
+			name = config.channels[i].DisplayName
+		}
+
+		channelResult := job.ChannelResults[channelID]
+		if config.isRemove() || config.isUndo() {
+			message += fmt.Sprintf("| %s | %d | %d | %d |\n", name, channelResult.RemovedUsers, channelResult.NotMember+channelResult.EmailNotFound, channelResult.ErrorUsers)
+		} else {
+			message += fmt.Sprintf("| %s | %d | %d | %d |\n", name, channelResult.AddedUsers, channelResult.NotAddedCount(), channelResult.ErrorUsers)
+		}
+	}
+
+	return message
+}
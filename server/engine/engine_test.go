@@ -1,9 +1,17 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/mocks"
@@ -23,11 +31,220 @@ func newValidEmptyConfig() *Config {
 	}
 }
 
+type memoryJobStore struct {
+	mu              sync.Mutex
+	jobs            map[string]Job
+	inputs          map[string][]AddUser
+	reports         map[string]map[int][]UserResult
+	claims          map[string]bool
+	cancelRequested map[string]bool
+	schedules       map[string]Schedule
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{
+		jobs:            map[string]Job{},
+		inputs:          map[string][]AddUser{},
+		reports:         map[string]map[int][]UserResult{},
+		claims:          map[string]bool{},
+		cancelRequested: map[string]bool{},
+		schedules:       map[string]Schedule{},
+	}
+}
+
+func (s *memoryJobStore) ClaimJob(job *Job) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := getJobClaimKey(job)
+	if s.claims[key] {
+		return false, nil
+	}
+	s.claims[key] = true
+	return true, nil
+}
+
+func (s *memoryJobStore) ReleaseJobClaim(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, getJobClaimKey(job))
+	return nil
+}
+
+func (s *memoryJobStore) CreateSchedule(schedule *Schedule) error {
+	return s.SaveSchedule(schedule)
+}
+
+func (s *memoryJobStore) SaveSchedule(schedule *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[schedule.ID] = *schedule
+	return nil
+}
+
+func (s *memoryJobStore) GetSchedule(scheduleID string) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule, ok := s.schedules[scheduleID]
+	if !ok {
+		return nil, kvstore.ErrNotFound
+	}
+	return &schedule, nil
+}
+
+func (s *memoryJobStore) ListSchedules() ([]*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := []*Schedule{}
+	for id := range s.schedules {
+		schedule := s.schedules[id]
+		schedules = append(schedules, &schedule)
+	}
+	return schedules, nil
+}
+
+func (s *memoryJobStore) DeleteSchedule(scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.schedules, scheduleID)
+	return nil
+}
+
+func (s *memoryJobStore) ClaimScheduleRun(schedule *Schedule) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := getScheduleRunClaimKey(schedule)
+	if s.claims[key] {
+		return false, nil
+	}
+	s.claims[key] = true
+	return true, nil
+}
+
+func (s *memoryJobStore) SaveJobInput(jobID string, users []AddUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputs[jobID] = users
+	return nil
+}
+
+func (s *memoryJobStore) GetJobInput(jobID string) ([]AddUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users, ok := s.inputs[jobID]
+	if !ok {
+		return nil, kvstore.ErrNotFound
+	}
+	return users, nil
+}
+
+func (s *memoryJobStore) DeleteJobInput(jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inputs, jobID)
+	return nil
+}
+
+func (s *memoryJobStore) CreateJob(job *Job) error {
+	return s.SaveJob(job, 0)
+}
+
+func (s *memoryJobStore) SaveJob(job *Job, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = *job
+	return nil
+}
+
+func (s *memoryJobStore) GetJob(jobID string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return nil, kvstore.ErrNotFound
+	}
+	return &job, nil
+}
+
+func (s *memoryJobStore) ListJobs(opts JobListOptions) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := []*Job{}
+	for id := range s.jobs {
+		job := s.jobs[id]
+		if (opts.ChannelID == "" || job.TargetsChannel(opts.ChannelID)) && (opts.UserID == "" || job.UserID == opts.UserID) {
+			jobs = append(jobs, &job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreateAt > jobs[j].CreateAt
+	})
+
+	start := opts.Page * opts.PerPage
+	if start > len(jobs) {
+		return []*Job{}, nil
+	}
+	end := start + opts.PerPage
+	if opts.PerPage == 0 || end > len(jobs) {
+		end = len(jobs)
+	}
+	return jobs[start:end], nil
+}
+
+func (s *memoryJobStore) ListActiveJobs() ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := []*Job{}
+	for id := range s.jobs {
+		job := s.jobs[id]
+		if !job.IsFinished() {
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
+
+func (s *memoryJobStore) RequestJobCancel(jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancelRequested[jobID] = true
+	return nil
+}
+
+func (s *memoryJobStore) IsJobCancelRequested(jobID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancelRequested[jobID], nil
+}
+
+func (s *memoryJobStore) SaveJobReportChunk(jobID string, chunk int, results []UserResult, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reports[jobID] == nil {
+		s.reports[jobID] = map[int][]UserResult{}
+	}
+	s.reports[jobID][chunk] = append([]UserResult{}, results...)
+	return nil
+}
+
+func (s *memoryJobStore) GetJobReport(jobID string) (Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := Report{}
+	for chunk := 0; ; chunk++ {
+		results, ok := s.reports[jobID][chunk]
+		if !ok {
+			return report, nil
+		}
+		report = append(report, results...)
+	}
+}
+
 type engineTestHelper struct {
 	ctrl *gomock.Controller
 
-	API *plugintest.API
-	KV  kvstore.LockStore
+	API  *plugintest.API
+	KV   kvstore.LockStore
+	Jobs *memoryJobStore
 }
 
 func (h *engineTestHelper) finish() {
@@ -40,6 +257,7 @@ func newEngineTestHelper(t *testing.T) *engineTestHelper {
 		ctrl: ctrl,
 		API:  plugintest.NewAPI(t),
 		KV:   mocks.NewMockLockStore(ctrl),
+		Jobs: newMemoryJobStore(),
 	}
 }
 
@@ -48,12 +266,12 @@ func TestEngineStartJobErrors(t *testing.T) {
 		t.Run("Locked channel should fail", func(t *testing.T) {
 			th := newEngineTestHelper(t)
 			defer th.finish()
-			engine := NewEngine(th.API, th.KV, "bot-user-id")
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 			cfg := newValidEmptyConfig()
 			th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(true)
 
-			err := engine.StartJob(context.TODO(), cfg)
+			_, err := engine.StartJob(context.TODO(), cfg)
 			require.Error(t, err)
 		})
 	})
@@ -61,7 +279,7 @@ func TestEngineStartJobErrors(t *testing.T) {
 	t.Run("GetChannel errors", func(t *testing.T) {
 		th := newEngineTestHelper(t)
 		defer th.finish()
-		engine := NewEngine(th.API, th.KV, "bot-user-id")
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 		cfg := newValidEmptyConfig()
 		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
@@ -72,7 +290,7 @@ func TestEngineStartJobErrors(t *testing.T) {
 		th.API.On("LogError", "error getting channnel information", "channel_id", cfg.ChannelID, "err", appErr.Error())
 		th.API.On("GetChannel", cfg.ChannelID).Return(nil, &appErr)
 
-		err := engine.StartJob(context.TODO(), cfg)
+		_, err := engine.StartJob(context.TODO(), cfg)
 		require.Error(t, err)
 	})
 
@@ -80,7 +298,7 @@ func TestEngineStartJobErrors(t *testing.T) {
 		t.Run("Group should fail", func(t *testing.T) {
 			th := newEngineTestHelper(t)
 			defer th.finish()
-			engine := NewEngine(th.API, th.KV, "bot-user-id")
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 			cfg := newValidEmptyConfig()
 			th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
@@ -88,14 +306,14 @@ func TestEngineStartJobErrors(t *testing.T) {
 				Type: model.ChannelTypeGroup,
 			}, nil)
 
-			err := engine.StartJob(context.TODO(), cfg)
+			_, err := engine.StartJob(context.TODO(), cfg)
 			require.Error(t, err)
 		})
 
 		t.Run("DM should fail", func(t *testing.T) {
 			th := newEngineTestHelper(t)
 			defer th.finish()
-			engine := NewEngine(th.API, th.KV, "bot-user-id")
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 			cfg := newValidEmptyConfig()
 			th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
@@ -103,7 +321,7 @@ func TestEngineStartJobErrors(t *testing.T) {
 				Type: model.ChannelTypeDirect,
 			}, nil)
 
-			err := engine.StartJob(context.TODO(), cfg)
+			_, err := engine.StartJob(context.TODO(), cfg)
 			require.Error(t, err)
 		})
 	})
@@ -112,7 +330,7 @@ func TestEngineStartJobErrors(t *testing.T) {
 		t.Run("private channel without permissions should fail", func(t *testing.T) {
 			th := newEngineTestHelper(t)
 			defer th.finish()
-			engine := NewEngine(th.API, th.KV, "bot-user-id")
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 			cfg := newValidEmptyConfig()
 			th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
@@ -122,14 +340,14 @@ func TestEngineStartJobErrors(t *testing.T) {
 			}, nil)
 			th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePrivateChannelMembers).Return(false)
 
-			err := engine.StartJob(context.TODO(), cfg)
+			_, err := engine.StartJob(context.TODO(), cfg)
 			require.Error(t, err)
 		})
 
 		t.Run("public channel without permissions should fail", func(t *testing.T) {
 			th := newEngineTestHelper(t)
 			defer th.finish()
-			engine := NewEngine(th.API, th.KV, "bot-user-id")
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 			cfg := newValidEmptyConfig()
 			th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
@@ -139,14 +357,14 @@ func TestEngineStartJobErrors(t *testing.T) {
 			}, nil)
 			th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(false)
 
-			err := engine.StartJob(context.TODO(), cfg)
+			_, err := engine.StartJob(context.TODO(), cfg)
 			require.Error(t, err)
 		})
 
 		t.Run("Add to team without permissions should fail", func(t *testing.T) {
 			th := newEngineTestHelper(t)
 			defer th.finish()
-			engine := NewEngine(th.API, th.KV, "bot-user-id")
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 			cfg := newValidEmptyConfig()
 			cfg.AddToTeam = true
@@ -159,7 +377,7 @@ func TestEngineStartJobErrors(t *testing.T) {
 			th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
 			th.API.On("HasPermissionToTeam", cfg.UserID, "team-id", model.PermissionAddUserToTeam).Return(false)
 
-			err := engine.StartJob(context.TODO(), cfg)
+			_, err := engine.StartJob(context.TODO(), cfg)
 			require.Error(t, err)
 		})
 	})
@@ -168,7 +386,7 @@ func TestEngineStartJobErrors(t *testing.T) {
 func TestStartJobSuccess(t *testing.T) {
 	th := newEngineTestHelper(t)
 	defer th.finish()
-	engine := NewEngine(th.API, th.KV, "bot-user-id")
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
 
 	cfg := newValidEmptyConfig()
 
@@ -178,14 +396,17 @@ func TestStartJobSuccess(t *testing.T) {
 		TeamId: "team-id",
 	}, nil)
 	th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
-	th.KV.(*mocks.MockLockStore).EXPECT().Lock(cfg.ChannelID).Return(nil)
+	th.KV.(*mocks.MockLockStore).EXPECT().Lock(cfg.ChannelID, gomock.Any()).Return(nil)
 
 	th.API.On("GetUser", cfg.UserID).Return(&model.User{
 		Id:       cfg.UserID,
 		Username: "username",
 	}, nil)
 	th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
-	th.KV.(*mocks.MockLockStore).EXPECT().Unlock(cfg.ChannelID).Return(nil)
+	th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+	th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+	th.API.On("UploadFile", mock.Anything, cfg.ChannelID, mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+	th.KV.(*mocks.MockLockStore).EXPECT().Unlock(cfg.ChannelID, gomock.Any()).Return(nil)
 
 	wg := sync.WaitGroup{}
 	wg.Add(1)
@@ -193,9 +414,2054 @@ func TestStartJobSuccess(t *testing.T) {
 	engine.SetOnFinish(func() {
 		wg.Done()
 	})
-	err := engine.StartJob(context.Background(), cfg)
+	job, err := engine.StartJob(context.Background(), cfg)
 	require.Nil(t, err)
+	require.NotNil(t, job)
 
 	// Wait for goroutine to finish
 	wg.Wait()
+
+	storedJob, jobErr := th.Jobs.GetJob(job.ID)
+	require.NoError(t, jobErr)
+	require.Equal(t, JobStateFinished, storedJob.State)
+	require.Equal(t, cfg.ChannelID, storedJob.ChannelID)
+	require.Equal(t, cfg.UserID, storedJob.UserID)
+	require.NotZero(t, storedJob.StartAt)
+	require.NotZero(t, storedJob.FinishAt)
+}
+
+func TestStartJobMultipleChannels(t *testing.T) {
+	t.Run("locked channel should fail", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.ChannelIDs = []string{"channel-1", "channel-2"}
+		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked("channel-1").Return(false)
+		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked("channel-2").Return(true)
+
+		_, err := engine.StartJob(context.TODO(), cfg)
+		require.NotNil(t, err)
+		require.Contains(t, err.Message(), "channel-2")
+	})
+
+	t.Run("users are processed in every channel", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.ChannelIDs = []string{"channel-1", "channel-2", "channel-1"}
+		cfg.Users = []AddUser{{UserID: "user-1"}, {UserID: "user-2"}}
+
+		notFoundErr := &model.AppError{StatusCode: http.StatusNotFound}
+		for i, teamID := range []string{"team-1", "team-2"} {
+			channelID := cfg.ChannelIDs[i]
+			th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(channelID).Return(false)
+			th.KV.(*mocks.MockLockStore).EXPECT().Lock(channelID, gomock.Any()).Return(nil)
+			th.KV.(*mocks.MockLockStore).EXPECT().Unlock(channelID, gomock.Any()).Return(nil)
+			th.API.On("GetChannel", channelID).Return(&model.Channel{
+				Id:          channelID,
+				DisplayName: fmt.Sprintf("Channel %d", i+1),
+				Type:        model.ChannelTypeOpen,
+				TeamId:      teamID,
+			}, nil)
+			th.API.On("HasPermissionToChannel", cfg.UserID, channelID, model.PermissionManagePublicChannelMembers).Return(true)
+			th.API.On("GetTeamStats", teamID).Return(&model.TeamStats{TotalMemberCount: 1000}, nil)
+			th.API.On("GetChannelMembersByIds", channelID, []string{"user-1", "user-2"}).Return(model.ChannelMembers{}, nil)
+			th.API.On("AddUserToChannel", channelID, "user-1", cfg.UserID).Return(&model.ChannelMember{}, nil)
+			th.API.On("GetTeamMember", teamID, "user-1").Return(&model.TeamMember{}, nil)
+		}
+		th.API.On("GetTeamMember", "team-1", "user-2").Return(&model.TeamMember{}, nil)
+		th.API.On("AddUserToChannel", "channel-1", "user-2", cfg.UserID).Return(&model.ChannelMember{}, nil)
+		th.API.On("GetTeamMember", "team-2", "user-2").Return(nil, notFoundErr)
+		th.API.On("LogInfo", "not inviting member since it doesn't belong to the team", "add_user_id", "user-2", "trigger_user_id", cfg.UserID, "channel_id", "channel-2", "team_id", "team-2")
+
+		th.API.On("GetUser", cfg.UserID).Return(&model.User{Id: cfg.UserID, Username: "username"}, nil)
+		th.API.On("GetUser", "user-1").Return(&model.User{Id: "user-1"}, nil)
+		th.API.On("GetUser", "user-2").Return(&model.User{Id: "user-2"}, nil)
+		th.API.On("UploadFile", mock.Anything, "channel-1", mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+
+		var posts []*model.Post
+		var postsLock sync.Mutex
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			postsLock.Lock()
+			defer postsLock.Unlock()
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{Id: "progress-post-id"}, nil)
+		var updatedPost *model.Post
+		th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			updatedPost = args.Get(0).(*model.Post)
+		}).Return(&model.Post{Id: "progress-post-id"}, nil)
+		var events []string
+		var finishedPayload map[string]any
+		th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, &model.WebsocketBroadcast{UserId: cfg.UserID, ChannelId: "channel-1"}).Run(func(args mock.Arguments) {
+			events = append(events, args.String(0))
+			if args.String(0) == WebSocketEventJobFinished {
+				finishedPayload = args.Get(1).(map[string]any)
+			}
+		}).Return()
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		engine.SetOnFinish(func() {
+			wg.Done()
+		})
+
+		job, err := engine.StartJob(context.Background(), cfg)
+		require.Nil(t, err)
+		wg.Wait()
+
+		storedJob, jobErr := th.Jobs.GetJob(job.ID)
+		require.NoError(t, jobErr)
+		require.Equal(t, []string{"channel-1", "channel-2"}, storedJob.ChannelIDs)
+		require.Equal(t, 4, storedJob.TotalUsers)
+		require.Equal(t, 4, storedJob.ProcessedUsers)
+		require.Equal(t, 3, storedJob.Result.AddedUsers)
+		require.Equal(t, 1, storedJob.Result.NotAddedNonTeamMember)
+		require.Equal(t, map[string]bulkChannelAddResult{
+			"channel-1": {AddedUsers: 2},
+			"channel-2": {AddedUsers: 1, NotAddedNonTeamMember: 1},
+		}, storedJob.ChannelResults)
+
+		report, reportErr := th.Jobs.GetJobReport(job.ID)
+		require.NoError(t, reportErr)
+		require.Equal(t, Report{
+			{Input: "user-1", UserID: "user-1", ChannelID: "channel-1", Outcome: OutcomeAdded},
+			{Input: "user-2", UserID: "user-2", ChannelID: "channel-1", Outcome: OutcomeAdded},
+			{Input: "user-1", UserID: "user-1", ChannelID: "channel-2", Outcome: OutcomeAdded},
+			{Input: "user-2", UserID: "user-2", ChannelID: "channel-2", Outcome: OutcomeNotAddedNonTeamMember},
+		}, report)
+
+		// The progress post is finalized with the result and the reports are attached to a reply
+		require.Len(t, posts, 2)
+		require.Equal(t, "Starting bulk add of 2 users in 2 channels (triggered by @username)", posts[0].Message)
+		require.Equal(t, "progress-post-id", posts[1].RootId)
+		require.Len(t, posts[1].FileIds, 2)
+		require.Equal(t, "progress-post-id", updatedPost.Id)
+		require.Contains(t, updatedPost.Message, "Bulk add process finished.")
+		require.Contains(t, updatedPost.Message, "| Channel 1 | 2 | 0 | 0 |")
+		require.Contains(t, updatedPost.Message, "| Channel 2 | 1 | 1 | 0 |")
+		require.Equal(t, "progress-post-id", storedJob.ProgressPostID)
+
+		require.Equal(t, []string{WebSocketEventJobStarted, WebSocketEventJobFinished}, events)
+		require.Equal(t, job.ID, finishedPayload["job_id"])
+		require.Equal(t, "add", finishedPayload["operation"])
+		require.Equal(t, "finished", finishedPayload["state"])
+		require.Equal(t, float64(4), finishedPayload["processed_users"])
+		require.Equal(t, float64(3), finishedPayload["result"].(map[string]any)["added_users"])
+	})
+}
+
+func TestStartJobCancelled(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	cfg := newValidEmptyConfig()
+	cfg.Users = []AddUser{{UserID: "user-1"}, {UserID: "user-2"}}
+
+	th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
+	th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+		Type:   model.ChannelTypeOpen,
+		TeamId: "team-id",
+	}, nil)
+	th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+	th.KV.(*mocks.MockLockStore).EXPECT().Lock(cfg.ChannelID, gomock.Any()).Return(nil)
+
+	th.API.On("GetUser", cfg.UserID).Return(&model.User{
+		Id:       cfg.UserID,
+		Username: "username",
+	}, nil)
+	th.API.On("LogInfo", "bulk job cancelled", "job_id", mock.Anything, "channel_id", cfg.ChannelID, "processed_users", 0)
+	th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+	th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+	th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+	th.API.On("UploadFile", mock.Anything, cfg.ChannelID, mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+	th.KV.(*mocks.MockLockStore).EXPECT().Unlock(cfg.ChannelID, gomock.Any()).Return(nil)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	engine.SetOnFinish(func() {
+		wg.Done()
+	})
+
+	// A cancelled context stops the job before processing any user
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	job, err := engine.StartJob(ctx, cfg)
+	require.Nil(t, err)
+
+	wg.Wait()
+
+	storedJob, jobErr := th.Jobs.GetJob(job.ID)
+	require.NoError(t, jobErr)
+	require.Equal(t, JobStateCancelled, storedJob.State)
+	require.Equal(t, 0, storedJob.ProcessedUsers)
+	require.Equal(t, 2, storedJob.TotalUsers)
+}
+
+func TestCancelJob(t *testing.T) {
+	t.Run("finished job should fail", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		err := engine.CancelJob(&Job{ID: "job-id", State: JobStateFinished})
+		require.Error(t, err)
+	})
+
+	t.Run("job running in another node should be flagged", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		err := engine.CancelJob(&Job{ID: "job-id", State: JobStateRunning})
+		require.Nil(t, err)
+
+		requested, requestErr := th.Jobs.IsJobCancelRequested("job-id")
+		require.NoError(t, requestErr)
+		require.True(t, requested)
+	})
+}
+
+func TestResumeJobs(t *testing.T) {
+	t.Run("interrupted job should resume from the last processed user", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		job := &Job{
+			ID:             "job-id",
+			ChannelID:      "channel-id",
+			ChannelIDs:     []string{"channel-id"},
+			UserID:         "user-id",
+			TotalUsers:     2,
+			ProcessedUsers: 1,
+			State:          JobStateInterrupted,
+			Result:         bulkChannelAddResult{AddedUsers: 1},
+			StartAt:        model.GetMillis(),
+			UpdateAt:       model.GetMillis(),
+		}
+		require.NoError(t, th.Jobs.SaveJob(job, 0))
+		require.NoError(t, th.Jobs.SaveJobInput(job.ID, []AddUser{{UserID: "user-1"}, {UserID: "user-2"}}))
+
+		th.API.On("GetChannel", job.ChannelID).Return(&model.Channel{
+			Id:     job.ChannelID,
+			Type:   model.ChannelTypeOpen,
+			TeamId: "team-id",
+		}, nil)
+		th.API.On("HasPermissionToChannel", job.UserID, job.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+		th.KV.(*mocks.MockLockStore).EXPECT().Lock(job.ChannelID, gomock.Any()).Return(nil)
+		th.API.On("LogInfo", "resuming bulk job", "job_id", job.ID, "channel_id", job.ChannelID, "processed_users", 1)
+		th.API.On("GetUser", job.UserID).Return(&model.User{Id: job.UserID, Username: "username"}, nil)
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+		th.API.On("UploadFile", mock.Anything, job.ChannelID, mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+		th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 1000}, nil)
+		th.API.On("GetUser", "user-2").Return(&model.User{Id: "user-2"}, nil)
+		th.API.On("GetChannelMembersByIds", job.ChannelID, []string{"user-2"}).Return(model.ChannelMembers{}, nil)
+		th.API.On("GetTeamMember", "team-id", "user-2").Return(&model.TeamMember{}, nil)
+		th.API.On("AddUserToChannel", job.ChannelID, "user-2", job.UserID).Return(&model.ChannelMember{}, nil)
+		th.KV.(*mocks.MockLockStore).EXPECT().Unlock(job.ChannelID, gomock.Any()).Return(nil)
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		engine.SetOnFinish(func() {
+			wg.Done()
+		})
+
+		require.NoError(t, engine.ResumeJobs())
+		wg.Wait()
+
+		storedJob, err := th.Jobs.GetJob(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, JobStateFinished, storedJob.State)
+		require.Equal(t, 2, storedJob.ProcessedUsers)
+		require.Equal(t, 2, storedJob.Result.AddedUsers)
+		th.API.AssertNotCalled(t, "AddUserToChannel", job.ChannelID, "user-1", job.UserID)
+
+		// The result of the first user was not stored before the interruption
+		report, err := th.Jobs.GetJobReport(job.ID)
+		require.NoError(t, err)
+		require.Equal(t, Report{
+			{Input: "user-1", Outcome: OutcomeUnknown},
+			{Input: "user-2", UserID: "user-2", Outcome: OutcomeAdded},
+		}, report)
+
+		_, err = th.Jobs.GetJobInput(job.ID)
+		require.ErrorIs(t, err, kvstore.ErrNotFound)
+	})
+
+	t.Run("running job renewing its locks should not resume", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		// Not updated for a long time, processing a slow batch
+		job := &Job{
+			ID:         "job-id",
+			ChannelID:  "channel-id",
+			ChannelIDs: []string{"channel-id"},
+			State:      JobStateRunning,
+			UpdateAt:   model.GetMillis() - time.Hour.Milliseconds(),
+		}
+		require.NoError(t, th.Jobs.SaveJob(job, 0))
+
+		th.KV.(*mocks.MockLockStore).EXPECT().GetLock(job.ChannelID).Return(&kvstore.LockInfo{
+			Owner:       job.ID,
+			LockedAt:    job.UpdateAt,
+			HeartbeatAt: model.GetMillis(),
+		}, nil)
+
+		require.NoError(t, engine.ResumeJobs())
+	})
+
+	t.Run("abandoned job should release its claim if the channels are locked", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		job := &Job{
+			ID:         "job-id",
+			ChannelID:  "channel-id",
+			ChannelIDs: []string{"channel-id"},
+			UserID:     "user-id",
+			State:      JobStateRunning,
+			UpdateAt:   model.GetMillis(),
+		}
+		require.NoError(t, th.Jobs.SaveJob(job, 0))
+		require.NoError(t, th.Jobs.SaveJobInput(job.ID, []AddUser{{UserID: "user-1"}}))
+
+		staleAt := model.GetMillis() - (2 * kvstore.StaleLockThreshold).Milliseconds()
+		th.KV.(*mocks.MockLockStore).EXPECT().GetLock(job.ChannelID).Return(&kvstore.LockInfo{
+			Owner:       job.ID,
+			LockedAt:    staleAt,
+			HeartbeatAt: staleAt,
+		}, nil)
+		th.API.On("GetChannel", job.ChannelID).Return(&model.Channel{
+			Id:     job.ChannelID,
+			Type:   model.ChannelTypeOpen,
+			TeamId: "team-id",
+		}, nil)
+		th.API.On("HasPermissionToChannel", job.UserID, job.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+		th.KV.(*mocks.MockLockStore).EXPECT().Unlock(job.ChannelID, job.ID).Return(nil)
+		th.KV.(*mocks.MockLockStore).EXPECT().Lock(job.ChannelID, job.ID).Return(kvstore.ErrIsLocked)
+		th.API.On("LogError", "error resuming job", "job_id", job.ID, "channel_id", job.ChannelID, "err", mock.Anything)
+
+		require.NoError(t, engine.ResumeJobs())
+
+		claimed, err := th.Jobs.ClaimJob(job)
+		require.NoError(t, err)
+		require.True(t, claimed)
+	})
+
+	t.Run("claimed job should not resume", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		job := &Job{
+			ID:       "job-id",
+			State:    JobStateInterrupted,
+			UpdateAt: model.GetMillis(),
+		}
+		require.NoError(t, th.Jobs.SaveJob(job, 0))
+
+		claimed, err := th.Jobs.ClaimJob(job)
+		require.NoError(t, err)
+		require.True(t, claimed)
+
+		require.NoError(t, engine.ResumeJobs())
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	cfg := newValidEmptyConfig()
+	cfg.AddToTeam = true
+	cfg.Users = []AddUser{
+		{UserID: "guest"},
+		{UserID: "non-team-member"},
+		{UserID: "channel-member"},
+		{Username: "team-member"},
+		{Username: "missing"},
+		{Email: " Email-User@Example.com"},
+		{Email: "missing@example.com"},
+	}
+
+	notFoundErr := &model.AppError{StatusCode: http.StatusNotFound}
+
+	th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+		Id:     cfg.ChannelID,
+		Type:   model.ChannelTypeOpen,
+		TeamId: "team-id",
+	}, nil)
+	th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+	th.API.On("HasPermissionToTeam", cfg.UserID, "team-id", model.PermissionAddUserToTeam).Return(true)
+	th.API.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Paging through the team takes more requests than checking the memberships one by one
+	th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 2000}, nil)
+
+	th.API.On("GetUsersByUsernames", []string{"team-member", "missing"}).Return([]*model.User{
+		{Id: "team-member", Username: "team-member"},
+	}, nil)
+	th.API.On("GetUser", "guest").Return(&model.User{Id: "guest", Roles: model.SystemGuestRoleId}, nil)
+	th.API.On("GetUser", "non-team-member").Return(&model.User{Id: "non-team-member"}, nil)
+	th.API.On("GetUser", "channel-member").Return(&model.User{Id: "channel-member"}, nil)
+	th.API.On("GetUserByEmail", "email-user@example.com").Return(&model.User{Id: "email-user"}, nil)
+	th.API.On("GetUserByEmail", "missing@example.com").Return(nil, notFoundErr)
+
+	th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"guest", "non-team-member", "channel-member", "team-member", "email-user"}).Return(model.ChannelMembers{
+		{ChannelId: cfg.ChannelID, UserId: "channel-member"},
+	}, nil)
+
+	th.API.On("GetTeamMember", "team-id", "non-team-member").Return(nil, notFoundErr)
+	th.API.On("GetTeamMember", "team-id", "team-member").Return(&model.TeamMember{}, nil)
+	th.API.On("GetTeamMember", "team-id", "email-user").Return(&model.TeamMember{}, nil)
+
+	dryRun, err := engine.DryRun(cfg)
+	require.Nil(t, err)
+
+	require.Equal(t, []UserResult{
+		{Input: "guest", UserID: "guest", Outcome: OutcomeNotAddedGuest},
+		{Input: "non-team-member", UserID: "non-team-member", Outcome: OutcomeAdded, AddedToTeam: true},
+		{Input: "channel-member", UserID: "channel-member", Outcome: OutcomeAlreadyMember},
+		{Input: "team-member", UserID: "team-member", Outcome: OutcomeAdded},
+		{Input: "missing", Outcome: OutcomeError, Error: dryRun.Users[4].Error},
+		{Input: " Email-User@Example.com", UserID: "email-user", Outcome: OutcomeAdded},
+		{Input: "missing@example.com", Outcome: OutcomeEmailNotFound},
+	}, dryRun.Users)
+	require.NotEmpty(t, dryRun.Users[4].Error)
+
+	require.Equal(t, 3, dryRun.Result.AddedUsers)
+	require.Equal(t, 1, dryRun.Result.EmailNotFound)
+	require.Equal(t, 1, dryRun.Result.AddedToTeam)
+	require.Equal(t, 1, dryRun.Result.NotAddedGuest)
+	require.Equal(t, 1, dryRun.Result.ErrorUsers)
+
+	th.API.AssertNotCalled(t, "CreateTeamMember", mock.Anything, mock.Anything)
+	th.API.AssertNotCalled(t, "AddUserToChannel", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGuestPolicy(t *testing.T) {
+	guestInTeam := UserResult{Input: "guest-in-team", UserID: "guest-in-team"}
+	guestNotInTeam := UserResult{Input: "guest-not-in-team", UserID: "guest-not-in-team"}
+
+	for _, tc := range []struct {
+		name                string
+		policy              GuestPolicy
+		settingsPolicy      GuestPolicy
+		guestAccountsEnable bool
+		canInviteGuests     bool
+		expectedError       string
+		expectedInTeam      UserOutcome
+		expectedNotInTeam   UserOutcome
+		expectedAddedToTeam bool
+	}{
+		{
+			name:                "guests are skipped by default",
+			guestAccountsEnable: true,
+			expectedInTeam:      OutcomeNotAddedGuest,
+			expectedNotInTeam:   OutcomeNotAddedGuest,
+		},
+		{
+			name:                "guests in the team are added",
+			policy:              GuestPolicyAllowTeamMembers,
+			guestAccountsEnable: true,
+			expectedInTeam:      OutcomeAdded,
+			expectedNotInTeam:   OutcomeNotAddedGuest,
+		},
+		{
+			name:                "the policy of the settings applies if the request doesn't set one",
+			settingsPolicy:      GuestPolicyAllowTeamMembers,
+			guestAccountsEnable: true,
+			expectedInTeam:      OutcomeAdded,
+			expectedNotInTeam:   OutcomeNotAddedGuest,
+		},
+		{
+			name:                "the policy of the request overrides the settings",
+			policy:              GuestPolicySkip,
+			settingsPolicy:      GuestPolicyAllowTeamMembers,
+			guestAccountsEnable: true,
+			expectedInTeam:      OutcomeNotAddedGuest,
+			expectedNotInTeam:   OutcomeNotAddedGuest,
+		},
+		{
+			name:                "guests are added to the team",
+			policy:              GuestPolicyAllowAndAddToTeam,
+			guestAccountsEnable: true,
+			canInviteGuests:     true,
+			expectedInTeam:      OutcomeAdded,
+			expectedNotInTeam:   OutcomeAdded,
+			expectedAddedToTeam: true,
+		},
+		{
+			name:                "adding guests to the team requires permission to invite guests",
+			policy:              GuestPolicyAllowAndAddToTeam,
+			guestAccountsEnable: true,
+			expectedError:       "Insufficient permissions",
+		},
+		{
+			name:                "the settings policy only adds guests in the team without permission to invite guests",
+			settingsPolicy:      GuestPolicyAllowAndAddToTeam,
+			guestAccountsEnable: true,
+			expectedInTeam:      OutcomeAdded,
+			expectedNotInTeam:   OutcomeNotAddedGuest,
+		},
+		{
+			name:              "guests are skipped when guest accounts are disabled",
+			policy:            GuestPolicyAllowAndAddToTeam,
+			expectedInTeam:    OutcomeNotAddedGuest,
+			expectedNotInTeam: OutcomeNotAddedGuest,
+		},
+		{
+			name:          "invalid policies should fail",
+			policy:        GuestPolicy("everyone"),
+			expectedError: "Invalid guest policy",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			th := newEngineTestHelper(t)
+			defer th.finish()
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+			engine.SetSettings(Settings{GuestPolicy: tc.settingsPolicy})
+
+			cfg := newValidEmptyConfig()
+			cfg.GuestPolicy = tc.policy
+			cfg.Users = []AddUser{{UserID: "guest-in-team"}, {UserID: "guest-not-in-team"}}
+
+			th.API.On("GetConfig").Return(&model.Config{
+				GuestAccountsSettings: model.GuestAccountsSettings{Enable: model.NewBool(tc.guestAccountsEnable)},
+			}).Maybe()
+			th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+				Id:     cfg.ChannelID,
+				Type:   model.ChannelTypeOpen,
+				TeamId: "team-id",
+			}, nil).Maybe()
+			th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true).Maybe()
+			th.API.On("HasPermissionToTeam", cfg.UserID, "team-id", model.PermissionInviteGuest).Return(tc.canInviteGuests).Maybe()
+			th.API.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+			th.API.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+			th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 2000}, nil).Maybe()
+			th.API.On("GetUser", "guest-in-team").Return(&model.User{Id: "guest-in-team", Roles: model.SystemGuestRoleId}, nil).Maybe()
+			th.API.On("GetUser", "guest-not-in-team").Return(&model.User{Id: "guest-not-in-team", Roles: model.SystemGuestRoleId}, nil).Maybe()
+			th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"guest-in-team", "guest-not-in-team"}).Return(model.ChannelMembers{}, nil).Maybe()
+			th.API.On("GetTeamMember", "team-id", "guest-in-team").Return(&model.TeamMember{}, nil).Maybe()
+			th.API.On("GetTeamMember", "team-id", "guest-not-in-team").Return(nil, &model.AppError{StatusCode: http.StatusNotFound}).Maybe()
+
+			dryRun, err := engine.DryRun(cfg)
+			if tc.expectedError != "" {
+				require.NotNil(t, err)
+				require.Contains(t, err.Message(), tc.expectedError)
+				return
+			}
+			require.Nil(t, err)
+
+			expectedInTeam := guestInTeam
+			expectedInTeam.Outcome = tc.expectedInTeam
+			expectedNotInTeam := guestNotInTeam
+			expectedNotInTeam.Outcome = tc.expectedNotInTeam
+			expectedNotInTeam.AddedToTeam = tc.expectedAddedToTeam
+			require.Equal(t, []UserResult{expectedInTeam, expectedNotInTeam}, dryRun.Users)
+		})
+	}
+}
+
+func TestGuestPolicyFromSettingsWithoutGuests(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+	engine.SetSettings(Settings{GuestPolicy: GuestPolicyAllowAndAddToTeam})
+
+	cfg := newValidEmptyConfig()
+	cfg.Users = []AddUser{{UserID: "user-id"}}
+
+	th.API.On("GetConfig").Return(&model.Config{
+		GuestAccountsSettings: model.GuestAccountsSettings{Enable: model.NewBool(true)},
+	})
+	th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{Id: cfg.ChannelID, Type: model.ChannelTypeOpen, TeamId: "team-id"}, nil)
+	th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+	th.API.On("HasPermissionToTeam", cfg.UserID, "team-id", model.PermissionInviteGuest).Return(false)
+	th.API.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	th.API.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 2000}, nil).Maybe()
+	th.API.On("GetUser", "user-id").Return(&model.User{Id: "user-id"}, nil)
+	th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"user-id"}).Return(model.ChannelMembers{}, nil).Maybe()
+	th.API.On("GetTeamMember", "team-id", "user-id").Return(&model.TeamMember{}, nil)
+
+	// Users without permission to invite guests can run operations with the default policy
+	dryRun, err := engine.DryRun(cfg)
+	require.Nil(t, err)
+	require.Equal(t, []UserResult{{Input: "user-id", UserID: "user-id", Outcome: OutcomeAdded}}, dryRun.Users)
+	require.Equal(t, GuestPolicyAllowTeamMembers, cfg.GuestPolicy)
+}
+
+func TestSkippedUsers(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		user            *model.User
+		allowBots       bool
+		expectedOutcome UserOutcome
+	}{
+		{
+			name:            "regular users are added",
+			user:            &model.User{Id: "user-id"},
+			expectedOutcome: OutcomeAdded,
+		},
+		{
+			name:            "deactivated users are skipped",
+			user:            &model.User{Id: "user-id", DeleteAt: 1},
+			expectedOutcome: OutcomeNotAddedDeactivated,
+		},
+		{
+			name:            "bots are skipped by default",
+			user:            &model.User{Id: "user-id", IsBot: true},
+			expectedOutcome: OutcomeNotAddedBot,
+		},
+		{
+			name:            "bots are added if allowed",
+			user:            &model.User{Id: "user-id", IsBot: true},
+			allowBots:       true,
+			expectedOutcome: OutcomeAdded,
+		},
+		{
+			name:            "deactivated bots are skipped even if bots are allowed",
+			user:            &model.User{Id: "user-id", IsBot: true, DeleteAt: 1},
+			allowBots:       true,
+			expectedOutcome: OutcomeNotAddedDeactivated,
+		},
+		{
+			name:            "remote users are skipped",
+			user:            &model.User{Id: "user-id", RemoteId: model.NewString("remote-id")},
+			expectedOutcome: OutcomeNotAddedRemote,
+		},
+		{
+			name:            "users with an empty remote ID are added",
+			user:            &model.User{Id: "user-id", RemoteId: model.NewString("")},
+			expectedOutcome: OutcomeAdded,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			th := newEngineTestHelper(t)
+			defer th.finish()
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+			engine.SetSettings(Settings{AllowBots: tc.allowBots})
+
+			cfg := newValidEmptyConfig()
+			cfg.Users = []AddUser{{UserID: "user-id"}}
+
+			th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+				Id:     cfg.ChannelID,
+				Type:   model.ChannelTypeOpen,
+				TeamId: "team-id",
+			}, nil).Maybe()
+			th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true).Maybe()
+			th.API.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+			th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 2000}, nil).Maybe()
+			th.API.On("GetUser", "user-id").Return(tc.user, nil)
+			th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"user-id"}).Return(model.ChannelMembers{}, nil).Maybe()
+			th.API.On("GetTeamMember", "team-id", "user-id").Return(&model.TeamMember{}, nil).Maybe()
+
+			dryRun, err := engine.DryRun(cfg)
+			require.Nil(t, err)
+			require.Equal(t, []UserResult{{Input: "user-id", UserID: "user-id", Outcome: tc.expectedOutcome}}, dryRun.Users)
+
+			expectedResult := bulkChannelAddResult{}
+			expectedResult.add(UserResult{Outcome: tc.expectedOutcome})
+			require.Equal(t, expectedResult, dryRun.Result)
+		})
+	}
+}
+
+func TestDryRunPrefetchedTeam(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	cfg := newValidEmptyConfig()
+	cfg.Users = []AddUser{{UserID: "team-member"}, {UserID: "non-team-member"}}
+
+	th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+		Id:     cfg.ChannelID,
+		Type:   model.ChannelTypeOpen,
+		TeamId: "team-id",
+	}, nil)
+	th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+	th.API.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 1}, nil)
+	th.API.On("GetUsersInTeam", "team-id", 0, teamUsersPerPage).Return([]*model.User{{Id: "team-member"}}, nil)
+	th.API.On("GetUser", "non-team-member").Return(&model.User{Id: "non-team-member"}, nil)
+	th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"team-member", "non-team-member"}).Return(model.ChannelMembers{}, nil)
+
+	dryRun, err := engine.DryRun(cfg)
+	require.Nil(t, err)
+
+	require.Equal(t, []UserResult{
+		{Input: "team-member", UserID: "team-member", Outcome: OutcomeAdded},
+		{Input: "non-team-member", UserID: "non-team-member", Outcome: OutcomeNotAddedNonTeamMember},
+	}, dryRun.Users)
+
+	th.API.AssertNotCalled(t, "GetUser", "team-member")
+	th.API.AssertNotCalled(t, "GetTeamMember", mock.Anything, mock.Anything)
+}
+
+func TestPrefetchTeamUsersLargeTeam(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	cfg := newValidEmptyConfig()
+	cfg.channel = &model.Channel{Id: cfg.ChannelID, TeamId: "team-id"}
+
+	// Fewer pages than users to process, but too many to keep the team in memory
+	th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 50000}, nil)
+
+	engine.prefetchTeamUsers(cfg, 500)
+	require.Nil(t, cfg.teamUsers)
+	th.API.AssertNotCalled(t, "GetUsersInTeam", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBulkRemove(t *testing.T) {
+	t.Run("default channels should fail", func(t *testing.T) {
+		for _, channelName := range []string{model.DefaultChannelName, "announcements"} {
+			th := newEngineTestHelper(t)
+			engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+			cfg := newValidEmptyConfig()
+			cfg.Operation = OperationRemove
+			th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
+			th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+				Name: channelName,
+				Type: model.ChannelTypeOpen,
+			}, nil)
+			th.API.On("GetConfig").Return(&model.Config{
+				TeamSettings: model.TeamSettings{ExperimentalDefaultChannels: []string{"announcements"}},
+			}).Maybe()
+
+			_, err := engine.StartJob(context.TODO(), cfg)
+			require.NotNil(t, err)
+			require.Contains(t, err.Message(), "default channel")
+			th.finish()
+		}
+	})
+
+	t.Run("removes channel members", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.Operation = OperationRemove
+		cfg.Users = []AddUser{{UserID: "member"}, {UserID: "non-member"}, {UserID: "failing"}}
+
+		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
+		th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+			Id:     cfg.ChannelID,
+			Name:   "contractors",
+			Type:   model.ChannelTypePrivate,
+			TeamId: "team-id",
+		}, nil)
+		th.API.On("GetConfig").Return(&model.Config{})
+		th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePrivateChannelMembers).Return(true)
+		th.KV.(*mocks.MockLockStore).EXPECT().Lock(cfg.ChannelID, gomock.Any()).Return(nil)
+		th.KV.(*mocks.MockLockStore).EXPECT().Unlock(cfg.ChannelID, gomock.Any()).Return(nil)
+
+		th.API.On("GetUser", cfg.UserID).Return(&model.User{Id: cfg.UserID, Username: "username"}, nil)
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+		th.API.On("UploadFile", mock.Anything, cfg.ChannelID, mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+		th.API.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		th.API.On("GetUser", "member").Return(&model.User{Id: "member"}, nil)
+		th.API.On("GetUser", "non-member").Return(&model.User{Id: "non-member"}, nil)
+		th.API.On("GetUser", "failing").Return(&model.User{Id: "failing"}, nil)
+		th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"member", "non-member", "failing"}).Return(model.ChannelMembers{
+			{ChannelId: cfg.ChannelID, UserId: "member"},
+			{ChannelId: cfg.ChannelID, UserId: "failing"},
+		}, nil)
+		th.API.On("DeleteChannelMember", cfg.ChannelID, "member").Return(nil)
+		th.API.On("DeleteChannelMember", cfg.ChannelID, "failing").Return(&model.AppError{StatusCode: http.StatusInternalServerError})
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		engine.SetOnFinish(func() {
+			wg.Done()
+		})
+
+		job, err := engine.StartJob(context.Background(), cfg)
+		require.Nil(t, err)
+		wg.Wait()
+
+		storedJob, jobErr := th.Jobs.GetJob(job.ID)
+		require.NoError(t, jobErr)
+		require.Equal(t, OperationRemove, storedJob.Operation)
+		require.Equal(t, JobStateFinished, storedJob.State)
+		require.Equal(t, 1, storedJob.Result.RemovedUsers)
+		require.Equal(t, 1, storedJob.Result.NotMember)
+		require.Equal(t, 1, storedJob.Result.ErrorUsers)
+		th.API.AssertNotCalled(t, "DeleteChannelMember", cfg.ChannelID, "non-member")
+		th.API.AssertNotCalled(t, "GetTeamMember", mock.Anything, mock.Anything)
+		th.API.AssertCalled(t, "PublishWebSocketEvent", WebSocketEventJobUserFailed, mock.MatchedBy(func(payload map[string]any) bool {
+			user, ok := payload["user"].(map[string]any)
+			return ok && payload["job_id"] == job.ID && user["user_id"] == "failing" && user["outcome"] == string(OutcomeError)
+		}), &model.WebsocketBroadcast{UserId: cfg.UserID, ChannelId: cfg.ChannelID})
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.Operation = OperationRemove
+		cfg.Users = []AddUser{{UserID: "member"}, {UserID: "non-member"}}
+
+		th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+			Id:   cfg.ChannelID,
+			Name: "contractors",
+			Type: model.ChannelTypeOpen,
+		}, nil)
+		th.API.On("GetConfig").Return(&model.Config{})
+		th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+		th.API.On("GetUser", "member").Return(&model.User{Id: "member"}, nil)
+		th.API.On("GetUser", "non-member").Return(&model.User{Id: "non-member"}, nil)
+		th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"member", "non-member"}).Return(model.ChannelMembers{
+			{ChannelId: cfg.ChannelID, UserId: "member"},
+		}, nil)
+
+		dryRun, err := engine.DryRun(cfg)
+		require.Nil(t, err)
+		require.Equal(t, []UserResult{
+			{Input: "member", UserID: "member", Outcome: OutcomeRemoved},
+			{Input: "non-member", UserID: "non-member", Outcome: OutcomeNotMember},
+		}, dryRun.Users)
+		th.API.AssertNotCalled(t, "DeleteChannelMember", mock.Anything, mock.Anything)
+	})
+}
+
+func TestCopyChannelMembers(t *testing.T) {
+	setup := func(t *testing.T) (*engineTestHelper, *Engine, *Config) {
+		th := newEngineTestHelper(t)
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.SourceChannelID = "source-id"
+		cfg.Users = nil
+
+		th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+			Id:     cfg.ChannelID,
+			Type:   model.ChannelTypeOpen,
+			TeamId: "team-id",
+		}, nil)
+		th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+
+		return th, engine, cfg
+	}
+
+	t.Run("members of the source channel are the users", func(t *testing.T) {
+		th, engine, cfg := setup(t)
+		defer th.finish()
+
+		firstPage := model.ChannelMembers{}
+		for i := 0; i < sourceMembersPerPage; i++ {
+			firstPage = append(firstPage, model.ChannelMember{UserId: fmt.Sprintf("user-%d", i)})
+		}
+		th.API.On("HasPermissionToChannel", cfg.UserID, "source-id", model.PermissionReadChannel).Return(true)
+		th.API.On("GetChannelMembers", "source-id", 0, sourceMembersPerPage).Return(firstPage, nil)
+		th.API.On("GetChannelMembers", "source-id", 1, sourceMembersPerPage).Return(model.ChannelMembers{{UserId: "last-user"}}, nil)
+
+		// Every user is already a member of the team and the channel
+		th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 1}, nil)
+		th.API.On("GetUsersInTeam", "team-id", 0, teamUsersPerPage).Return([]*model.User{}, nil)
+		th.API.On("GetUser", mock.Anything).Return(func(userID string) *model.User {
+			return &model.User{Id: userID}
+		}, nil)
+		th.API.On("GetChannelMembersByIds", cfg.ChannelID, mock.Anything).Return(func(_ string, userIDs []string) model.ChannelMembers {
+			members := model.ChannelMembers{}
+			for _, userID := range userIDs {
+				members = append(members, model.ChannelMember{UserId: userID})
+			}
+			return members
+		}, nil)
+
+		dryRun, err := engine.DryRun(cfg)
+		require.Nil(t, err)
+		require.Len(t, dryRun.Users, sourceMembersPerPage+1)
+		require.Equal(t, "last-user", dryRun.Users[sourceMembersPerPage].Input)
+		require.Equal(t, OutcomeAlreadyMember, dryRun.Users[sourceMembersPerPage].Outcome)
+	})
+
+	t.Run("source channel without read permission should fail", func(t *testing.T) {
+		th, engine, cfg := setup(t)
+		defer th.finish()
+
+		th.API.On("HasPermissionToChannel", cfg.UserID, "source-id", model.PermissionReadChannel).Return(false)
+
+		_, err := engine.DryRun(cfg)
+		require.NotNil(t, err)
+		th.API.AssertNotCalled(t, "GetChannelMembers", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("source channel as target should fail", func(t *testing.T) {
+		th, engine, cfg := setup(t)
+		defer th.finish()
+
+		cfg.SourceChannelID = cfg.ChannelID
+
+		_, err := engine.DryRun(cfg)
+		require.NotNil(t, err)
+	})
+}
+
+func TestGroupAndTeamSources(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	cfg := newValidEmptyConfig()
+	cfg.SourceGroupID = "group-id"
+	cfg.SourceTeamID = "source-team-id"
+	cfg.Users = []AddUser{{UserID: "user-1"}}
+
+	th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+		Id:     cfg.ChannelID,
+		Type:   model.ChannelTypeOpen,
+		TeamId: "team-id",
+	}, nil)
+	th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+
+	th.API.On("GetGroup", "group-id").Return(&model.Group{Id: "group-id"}, nil)
+	th.API.On("GetGroupMemberUsers", "group-id", 0, sourceMembersPerPage).Return([]*model.User{
+		{Id: "user-1"},
+		{Id: "user-2"},
+		{Id: "guest"},
+	}, nil)
+	th.API.On("HasPermissionToTeam", cfg.UserID, "source-team-id", model.PermissionViewTeam).Return(true)
+	th.API.On("GetTeamMembers", "source-team-id", 0, sourceMembersPerPage).Return([]*model.TeamMember{
+		{UserId: "user-2"},
+		{UserId: "user-3"},
+		{UserId: "left-team", DeleteAt: 1},
+	}, nil)
+
+	th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 1000}, nil)
+	th.API.On("GetUser", "guest").Return(&model.User{Id: "guest", Roles: model.SystemGuestRoleId}, nil)
+	th.API.On("LogInfo", "not inviting guest user", "add_user_id", "guest", "trigger_user_id", cfg.UserID, "channel_id", cfg.ChannelID)
+	for _, userID := range []string{"user-1", "user-2", "user-3"} {
+		th.API.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		th.API.On("GetTeamMember", "team-id", userID).Return(&model.TeamMember{}, nil)
+	}
+	th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"user-1", "user-2", "guest", "user-3"}).Return(model.ChannelMembers{}, nil)
+
+	dryRun, err := engine.DryRun(cfg)
+	require.Nil(t, err)
+	require.Equal(t, []UserResult{
+		{Input: "user-1", UserID: "user-1", Outcome: OutcomeAdded},
+		{Input: "user-2", UserID: "user-2", Outcome: OutcomeAdded},
+		{Input: "guest", UserID: "guest", Outcome: OutcomeNotAddedGuest},
+		{Input: "user-3", UserID: "user-3", Outcome: OutcomeAdded},
+	}, dryRun.Users)
+}
+
+func TestSyncChannel(t *testing.T) {
+	setup := func(t *testing.T, removeExtras bool) (*engineTestHelper, *Engine, *Config) {
+		th := newEngineTestHelper(t)
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.Operation = OperationSync
+		cfg.RemoveExtras = removeExtras
+		cfg.Users = []AddUser{{UserID: "listed-member"}, {UserID: "new-user"}}
+
+		th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+			Id:     cfg.ChannelID,
+			Name:   "engineering",
+			Type:   model.ChannelTypeOpen,
+			TeamId: "team-id",
+		}, nil)
+		th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+
+		th.API.On("GetChannelMembers", cfg.ChannelID, 0, channelMembersPerPage).Return(model.ChannelMembers{
+			{UserId: "listed-member"},
+			{UserId: "extra"},
+			{UserId: "channel-admin", SchemeAdmin: true},
+			{UserId: "bot"},
+			{UserId: "system-admin"},
+			{UserId: cfg.UserID},
+		}, nil)
+		th.API.On("GetUsersInChannel", cfg.ChannelID, "username", 0, channelMembersPerPage).Return([]*model.User{
+			{Id: "listed-member", Username: "listed"},
+			{Id: "extra"},
+			{Id: "channel-admin"},
+			{Id: "bot", IsBot: true},
+			{Id: "system-admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId},
+			{Id: cfg.UserID},
+		}, nil)
+		th.API.On("GetUser", "listed-member").Return(&model.User{Id: "listed-member"}, nil).Maybe()
+		th.API.On("GetUser", "new-user").Return(&model.User{Id: "new-user"}, nil)
+		if removeExtras {
+			th.API.On("GetConfig").Return(&model.Config{})
+			th.API.On("GetUser", "extra").Return(&model.User{Id: "extra"}, nil)
+		}
+
+		th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 1000}, nil)
+		th.API.On("GetChannelMembersByIds", cfg.ChannelID, mock.Anything).Return(model.ChannelMembers{
+			{UserId: "listed-member"},
+			{UserId: "extra"},
+		}, nil)
+		th.API.On("GetTeamMember", "team-id", "new-user").Return(&model.TeamMember{}, nil)
+
+		return th, engine, cfg
+	}
+
+	t.Run("dry run reports the diff", func(t *testing.T) {
+		th, engine, cfg := setup(t, true)
+		defer th.finish()
+
+		dryRun, err := engine.DryRun(cfg)
+		require.Nil(t, err)
+		require.Equal(t, &SyncDiff{ToAdd: 1, ToRemove: 1, Unchanged: 1, Kept: 4}, dryRun.SyncDiff)
+		th.API.AssertNotCalled(t, "GetUser", "bot")
+		th.API.AssertNotCalled(t, "GetUser", "system-admin")
+		require.Equal(t, []UserResult{
+			{Input: "listed-member", UserID: "listed-member", Outcome: OutcomeAlreadyMember},
+			{Input: "new-user", UserID: "new-user", Outcome: OutcomeAdded},
+			{Input: "extra", UserID: "extra", Outcome: OutcomeRemoved},
+		}, dryRun.Users)
+	})
+
+	t.Run("extra members are kept unless requested", func(t *testing.T) {
+		th, engine, cfg := setup(t, false)
+		defer th.finish()
+
+		dryRun, err := engine.DryRun(cfg)
+		require.Nil(t, err)
+		require.Equal(t, &SyncDiff{ToAdd: 1, Unchanged: 1, Kept: 5}, dryRun.SyncDiff)
+		require.Len(t, dryRun.Users, 2)
+	})
+
+	t.Run("adds missing users and removes extra members", func(t *testing.T) {
+		th, engine, cfg := setup(t, true)
+		defer th.finish()
+
+		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
+		th.KV.(*mocks.MockLockStore).EXPECT().Lock(cfg.ChannelID, gomock.Any()).Return(nil)
+		th.KV.(*mocks.MockLockStore).EXPECT().Unlock(cfg.ChannelID, gomock.Any()).Return(nil)
+		th.API.On("GetUser", cfg.UserID).Return(&model.User{Id: cfg.UserID, Username: "username"}, nil)
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+		th.API.On("UploadFile", mock.Anything, cfg.ChannelID, mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+		th.API.On("AddUserToChannel", cfg.ChannelID, mock.AnythingOfType("string"), cfg.UserID).Return(&model.ChannelMember{}, nil)
+		th.API.On("DeleteChannelMember", cfg.ChannelID, "extra").Return(nil)
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		engine.SetOnFinish(func() {
+			wg.Done()
+		})
+
+		job, err := engine.StartJob(context.Background(), cfg)
+		require.Nil(t, err)
+		wg.Wait()
+
+		storedJob, jobErr := th.Jobs.GetJob(job.ID)
+		require.NoError(t, jobErr)
+		require.Equal(t, JobStateFinished, storedJob.State)
+		require.Equal(t, 3, storedJob.TotalUsers)
+		require.Equal(t, &SyncDiff{ToAdd: 1, ToRemove: 1, Unchanged: 1, Kept: 4}, storedJob.SyncDiff)
+		require.Equal(t, 1, storedJob.Result.RemovedUsers)
+		th.API.AssertCalled(t, "AddUserToChannel", cfg.ChannelID, "new-user", cfg.UserID)
+		th.API.AssertNumberOfCalls(t, "DeleteChannelMember", 1)
+
+		// Every user is looked up once, while being processed
+		th.API.AssertNumberOfCalls(t, "GetUser", 4)
+	})
+
+	t.Run("users listed by username should be matched with the channel users", func(t *testing.T) {
+		th, engine, cfg := setup(t, false)
+		defer th.finish()
+
+		cfg.Users = []AddUser{{Username: "Listed"}, {UserID: "new-user"}}
+		th.API.On("GetUsersByUsernames", []string{"listed"}).Return([]*model.User{{Id: "listed-member", Username: "listed"}}, nil)
+
+		dryRun, err := engine.DryRun(cfg)
+		require.Nil(t, err)
+		require.Equal(t, &SyncDiff{ToAdd: 1, Unchanged: 1, Kept: 5}, dryRun.SyncDiff)
+		th.API.AssertNotCalled(t, "GetUser", "listed-member")
+	})
+
+	t.Run("multiple channels should fail", func(t *testing.T) {
+		th := newEngineTestHelper(t)
+		defer th.finish()
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.Operation = OperationSync
+		cfg.ChannelIDs = []string{"channel-1", "channel-2"}
+		cfg.Users = []AddUser{{UserID: "user-1"}}
+
+		_, err := engine.DryRun(cfg)
+		require.NotNil(t, err)
+		th.API.AssertNotCalled(t, "GetChannelMembers", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUndoRecordsDeferTeamRemovals(t *testing.T) {
+	records, users := newUndoRecords("channel-1", Report{
+		{Input: "user-1", UserID: "user-1", ChannelID: "channel-1", Outcome: OutcomeAdded, AddedToTeam: true},
+		{Input: "user-2", UserID: "user-2", ChannelID: "channel-1", Outcome: OutcomeAdded},
+		{Input: "user-1", UserID: "user-1", ChannelID: "channel-2", Outcome: OutcomeAdded},
+		{Input: "user-2", UserID: "user-2", ChannelID: "channel-2", Outcome: OutcomeAlreadyMember},
+		{Input: "user-1", UserID: "user-1", ChannelID: "other-team-channel", Outcome: OutcomeAdded, AddedToTeam: true},
+	})
+	require.Equal(t, []AddUser{{UserID: "user-1"}, {UserID: "user-2"}}, users)
+
+	records.deferTeamRemovals([]*model.Channel{
+		{Id: "channel-1", TeamId: "team-1"},
+		{Id: "other-team-channel", TeamId: "team-2"},
+		{Id: "channel-2", TeamId: "team-1"},
+	})
+
+	// The team membership is removed in the last channel of the team, after the channel ones
+	require.Equal(t, undoRecords{
+		"channel-1": {
+			"user-1": {channel: true},
+			"user-2": {channel: true},
+		},
+		"channel-2": {
+			"user-1": {channel: true, team: true},
+		},
+		"other-team-channel": {
+			"user-1": {channel: true, team: true},
+		},
+	}, records)
+}
+
+func TestUndoJob(t *testing.T) {
+	setup := func(t *testing.T) (*engineTestHelper, *Engine, *Job) {
+		th := newEngineTestHelper(t)
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+		engine.SetSettings(Settings{UndoWindowHours: 24})
+
+		job := &Job{
+			ID:        "job-id",
+			Operation: OperationAdd,
+			ChannelID: "test",
+			UserID:    "user-id",
+			State:     JobStateFinished,
+			FinishAt:  model.GetMillis(),
+		}
+		require.NoError(t, th.Jobs.SaveJob(job, 0))
+		require.NoError(t, th.Jobs.SaveJobReportChunk(job.ID, 0, []UserResult{
+			{Input: "added", UserID: "added", Outcome: OutcomeAdded},
+			{Input: "added-to-team", UserID: "added-to-team", Outcome: OutcomeAdded, AddedToTeam: true},
+			{Input: "member", UserID: "member", Outcome: OutcomeAlreadyMember},
+			{Input: "missing@example.com", Outcome: OutcomeEmailNotFound},
+		}, 0))
+
+		return th, engine, job
+	}
+
+	t.Run("removes the memberships created by the job", func(t *testing.T) {
+		th, engine, job := setup(t)
+		defer th.finish()
+
+		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked("test").Return(false)
+		th.KV.(*mocks.MockLockStore).EXPECT().Lock("test", gomock.Any()).Return(nil)
+		th.KV.(*mocks.MockLockStore).EXPECT().Unlock("test", gomock.Any()).Return(nil)
+		th.API.On("GetChannel", "test").Return(&model.Channel{
+			Id:     "test",
+			Type:   model.ChannelTypeOpen,
+			TeamId: "team-id",
+		}, nil)
+		th.API.On("HasPermissionToChannel", "user-id", "test", model.PermissionManagePublicChannelMembers).Return(true)
+		th.API.On("HasPermissionToTeam", "user-id", "team-id", model.PermissionRemoveUserFromTeam).Return(true)
+		th.API.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "username"}, nil)
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+		th.API.On("UploadFile", mock.Anything, "test", mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+
+		th.API.On("GetUser", "added").Return(&model.User{Id: "added"}, nil)
+		th.API.On("GetUser", "added-to-team").Return(&model.User{Id: "added-to-team"}, nil)
+		th.API.On("GetChannelMembersByIds", "test", []string{"added", "added-to-team"}).Return(model.ChannelMembers{
+			{UserId: "added"},
+			{UserId: "added-to-team"},
+		}, nil)
+		th.API.On("DeleteChannelMember", "test", "added").Return(nil)
+		th.API.On("DeleteChannelMember", "test", "added-to-team").Return(nil)
+		th.API.On("DeleteTeamMember", "team-id", "added-to-team", "user-id").Return(nil)
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		engine.SetOnFinish(func() {
+			wg.Done()
+		})
+
+		undoJob, err := engine.UndoJob(context.Background(), job, "user-id")
+		require.Nil(t, err)
+		wg.Wait()
+
+		storedUndoJob, jobErr := th.Jobs.GetJob(undoJob.ID)
+		require.NoError(t, jobErr)
+		require.Equal(t, OperationUndo, storedUndoJob.Operation)
+		require.Equal(t, job.ID, storedUndoJob.UndoOfJobID)
+		require.Equal(t, JobStateFinished, storedUndoJob.State)
+		require.Equal(t, 2, storedUndoJob.Result.RemovedUsers)
+		require.Equal(t, 1, storedUndoJob.Result.RemovedFromTeam)
+		th.API.AssertNotCalled(t, "DeleteChannelMember", "test", "member")
+
+		storedJob, jobErr := th.Jobs.GetJob(job.ID)
+		require.NoError(t, jobErr)
+		require.Equal(t, undoJob.ID, storedJob.UndoJobID)
+
+		_, err = engine.UndoJob(context.Background(), storedJob, "user-id")
+		require.NotNil(t, err)
+		require.Contains(t, err.Message(), "already been undone")
+	})
+
+	t.Run("multi channel jobs should only undo the memberships of each channel", func(t *testing.T) {
+		th, engine, job := setup(t)
+		defer th.finish()
+
+		job.ChannelIDs = []string{"test", "other"}
+		require.NoError(t, th.Jobs.SaveJob(job, 0))
+		require.NoError(t, th.Jobs.SaveJobReportChunk(job.ID, 0, []UserResult{
+			{Input: "user-1", UserID: "user-1", ChannelID: "test", Outcome: OutcomeAdded},
+			{Input: "user-2", UserID: "user-2", ChannelID: "test", Outcome: OutcomeError, AddedToTeam: true},
+			{Input: "user-1", UserID: "user-1", ChannelID: "other", Outcome: OutcomeAlreadyMember},
+			{Input: "user-3", UserID: "user-3", ChannelID: "other", Outcome: OutcomeAdded},
+		}, 0))
+
+		lockStore := th.KV.(*mocks.MockLockStore)
+		for _, channelID := range job.ChannelIDs {
+			lockStore.EXPECT().IsLocked(channelID).Return(false)
+			lockStore.EXPECT().Lock(channelID, gomock.Any()).Return(nil)
+			lockStore.EXPECT().Unlock(channelID, gomock.Any()).Return(nil)
+			th.API.On("GetChannel", channelID).Return(&model.Channel{
+				Id:          channelID,
+				Type:        model.ChannelTypeOpen,
+				TeamId:      "team-id",
+				DisplayName: channelID,
+			}, nil)
+			th.API.On("HasPermissionToChannel", "user-id", channelID, model.PermissionManagePublicChannelMembers).Return(true)
+			th.API.On("GetChannelMembersByIds", channelID, mock.Anything).Return(model.ChannelMembers{
+				{UserId: "user-1"},
+				{UserId: "user-2"},
+				{UserId: "user-3"},
+			}, nil)
+		}
+		th.API.On("HasPermissionToTeam", "user-id", "team-id", model.PermissionRemoveUserFromTeam).Return(true)
+		th.API.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "username"}, nil)
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+		th.API.On("UploadFile", mock.Anything, "test", mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+		for _, userID := range []string{"user-1", "user-2", "user-3"} {
+			th.API.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		}
+		th.API.On("DeleteChannelMember", "test", "user-1").Return(nil).Once()
+		th.API.On("DeleteChannelMember", "other", "user-3").Return(nil).Once()
+		th.API.On("DeleteTeamMember", "team-id", "user-2", "user-id").Return(nil).Once()
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		engine.SetOnFinish(func() {
+			wg.Done()
+		})
+
+		undoJob, err := engine.UndoJob(context.Background(), job, "user-id")
+		require.Nil(t, err)
+		wg.Wait()
+
+		storedUndoJob, jobErr := th.Jobs.GetJob(undoJob.ID)
+		require.NoError(t, jobErr)
+		require.Equal(t, JobStateFinished, storedUndoJob.State)
+		require.Equal(t, 2, storedUndoJob.Result.RemovedUsers)
+		require.Equal(t, 1, storedUndoJob.Result.RemovedFromTeam)
+		require.Zero(t, storedUndoJob.Result.NotMember)
+
+		report, reportErr := th.Jobs.GetJobReport(undoJob.ID)
+		require.NoError(t, reportErr)
+		require.Equal(t, Report{
+			{Input: "user-1", UserID: "user-1", ChannelID: "test", Outcome: OutcomeRemoved},
+			{Input: "user-2", UserID: "user-2", ChannelID: "test", Outcome: OutcomeNotAddedByJob},
+			{Input: "user-3", UserID: "user-3", ChannelID: "test", Outcome: OutcomeNotAddedByJob},
+			{Input: "user-1", UserID: "user-1", ChannelID: "other", Outcome: OutcomeNotAddedByJob},
+			{Input: "user-2", UserID: "user-2", ChannelID: "other", Outcome: OutcomeNotAddedByJob, RemovedFromTeam: true},
+			{Input: "user-3", UserID: "user-3", ChannelID: "other", Outcome: OutcomeRemoved},
+		}, report)
+		th.API.AssertNotCalled(t, "DeleteChannelMember", "other", "user-1")
+		th.API.AssertNotCalled(t, "DeleteChannelMember", "test", "user-3")
+	})
+
+	t.Run("jobs older than the undo window should fail", func(t *testing.T) {
+		th, engine, job := setup(t)
+		defer th.finish()
+
+		job.FinishAt = model.GetMillis() - (25 * time.Hour).Milliseconds()
+
+		_, err := engine.UndoJob(context.Background(), job, "user-id")
+		require.NotNil(t, err)
+		require.Contains(t, err.Message(), "can no longer be undone")
+	})
+
+	t.Run("disabled undo should fail", func(t *testing.T) {
+		th, engine, job := setup(t)
+		defer th.finish()
+
+		engine.SetSettings(Settings{})
+
+		_, err := engine.UndoJob(context.Background(), job, "user-id")
+		require.NotNil(t, err)
+	})
+
+	t.Run("remove jobs should fail", func(t *testing.T) {
+		th, engine, job := setup(t)
+		defer th.finish()
+
+		job.Operation = OperationRemove
+
+		_, err := engine.UndoJob(context.Background(), job, "user-id")
+		require.NotNil(t, err)
+	})
+}
+
+func TestProgressTracker(t *testing.T) {
+	setup := func(t *testing.T) (*engineTestHelper, *Engine, *Config, *Job) {
+		th := newEngineTestHelper(t)
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+		engine.SetSettings(Settings{ProgressUpdateIntervalSeconds: 10})
+
+		cfg := newValidEmptyConfig()
+		job := newJob(cfg)
+		job.TotalUsers = 100
+		return th, engine, cfg, job
+	}
+
+	t.Run("progress should be updated after the interval", func(t *testing.T) {
+		th, engine, cfg, job := setup(t)
+		defer th.finish()
+
+		th.API.On("CreatePost", &model.Post{ChannelId: cfg.ChannelID, UserId: "bot-user-id", Message: "Starting"}).Return(&model.Post{Id: "post-id", Message: "Starting"}, nil)
+
+		tracker := engine.newProgressTracker(cfg, job, "Starting")
+		require.Equal(t, "post-id", job.ProgressPostID)
+		require.Equal(t, 10*time.Second, tracker.interval)
+
+		// The interval didn't elapse yet
+		tracker.update()
+		th.API.AssertNotCalled(t, "UpdatePost", mock.Anything)
+
+		job.ProcessedUsers = 50
+		job.Result = bulkChannelAddResult{AddedUsers: 48, ErrorUsers: 2}
+		tracker.lastUpdateAt = time.Now().Add(-time.Minute)
+
+		th.API.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Id == "post-id" &&
+				strings.HasPrefix(post.Message, "Starting\n\n") &&
+				strings.Contains(post.Message, "50 of 100 users processed (50%), 48 added, 2 errors.") &&
+				strings.Contains(post.Message, "Estimated time remaining:") &&
+				!strings.Contains(post.Message, "calculating")
+		})).Return(&model.Post{Id: "post-id"}, nil).Once()
+
+		tracker.update()
+	})
+
+	t.Run("resumed jobs should update their progress post", func(t *testing.T) {
+		th, engine, cfg, job := setup(t)
+		defer th.finish()
+
+		job.ProgressPostID = "post-id"
+		job.ProcessedUsers = 50
+		th.API.On("GetPost", "post-id").Return(&model.Post{
+			Id:        "post-id",
+			ChannelId: cfg.ChannelID,
+			UserId:    "bot-user-id",
+			RootId:    "root-id",
+			Message:   "Starting",
+			Props:     model.StringInterface{"from_bot": "true"},
+		}, nil).Once()
+		th.API.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Id == "post-id" &&
+				post.RootId == "root-id" &&
+				post.GetProp("from_bot") == "true" &&
+				strings.Contains(post.Message, "Estimated time remaining: calculating.")
+		})).Return(&model.Post{Id: "post-id"}, nil).Once()
+
+		tracker := engine.newProgressTracker(cfg, job, "Resuming")
+		require.Equal(t, "post-id", tracker.post.Id)
+		th.API.AssertNotCalled(t, "CreatePost", mock.Anything)
+	})
+
+	t.Run("resumed jobs should create a new progress post if the old one is gone", func(t *testing.T) {
+		th, engine, cfg, job := setup(t)
+		defer th.finish()
+
+		job.ProgressPostID = "post-id"
+		appErr := model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusNotFound)
+		th.API.On("GetPost", "post-id").Return(nil, appErr).Once()
+		th.API.On("LogWarn", "error updating progress post of resumed job, creating a new one", "job_id", job.ID, "post_id", "post-id", "err", appErr.Error()).Return().Once()
+		th.API.On("CreatePost", &model.Post{ChannelId: cfg.ChannelID, UserId: "bot-user-id", Message: "Resuming"}).Return(&model.Post{Id: "new-post-id"}, nil).Once()
+
+		tracker := engine.newProgressTracker(cfg, job, "Resuming")
+		require.Equal(t, "new-post-id", tracker.post.Id)
+		require.Equal(t, "new-post-id", job.ProgressPostID)
+		th.API.AssertNotCalled(t, "UpdatePost", mock.Anything)
+	})
+
+	t.Run("progress should not be updated without interval", func(t *testing.T) {
+		th, engine, cfg, job := setup(t)
+		defer th.finish()
+
+		engine.SetSettings(Settings{})
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: "post-id"}, nil)
+
+		tracker := engine.newProgressTracker(cfg, job, "Starting")
+		tracker.lastUpdateAt = time.Now().Add(-time.Hour)
+		tracker.update()
+		th.API.AssertNotCalled(t, "UpdatePost", mock.Anything)
+	})
+}
+
+func TestGetJob(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	require.NoError(t, th.Jobs.SaveJob(&Job{ID: "job-id", UserID: "user-id"}, 0))
+	th.API.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+	th.API.On("HasPermissionTo", "other-id", model.PermissionManageSystem).Return(false)
+
+	for _, userID := range []string{"user-id", "admin-id"} {
+		job, err := engine.GetJob(userID, "job-id")
+		require.NoError(t, err)
+		require.Equal(t, "job-id", job.ID)
+	}
+
+	// Jobs of other users are not found
+	_, err := engine.GetJob("other-id", "job-id")
+	require.ErrorIs(t, err, kvstore.ErrNotFound)
+}
+
+func TestHeartbeatLocks(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	lockStore := th.KV.(*mocks.MockLockStore)
+	lockStore.EXPECT().Renew("channel-1", "job-id").Return(nil).MinTimes(1)
+	lockStore.EXPECT().Renew("channel-2", "job-id").Return(errors.New("kv error")).MinTimes(1)
+	th.API.On("LogWarn", "error renewing channel lock", "job_id", "job-id", "channel_id", "channel-2", "err", "kv error").Return()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	engine.addRunningJob("job-id", cancel)
+
+	stop := engine.heartbeatLocks([]string{"channel-1", "channel-2"}, "job-id", 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	// Errors of the store don't stop the job
+	require.NoError(t, ctx.Err())
+
+	// No renewals after the job stops
+	lockStore.EXPECT().Renew(gomock.Any(), gomock.Any()).Times(0)
+	time.Sleep(30 * time.Millisecond)
+}
+
+func TestHeartbeatLocksLost(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+	lockStore := th.KV.(*mocks.MockLockStore)
+	lockStore.EXPECT().Renew("channel-1", "job-id").Return(nil).Times(1)
+	lockStore.EXPECT().Renew("channel-2", "job-id").Return(kvstore.ErrNotLockOwner).Times(1)
+	th.API.On("LogError", "channel lock taken by another job, interrupting job", "job_id", "job-id", "channel_id", "channel-2", "err", kvstore.ErrNotLockOwner.Error()).Return().Once()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	engine.addRunningJob("job-id", cancel)
+
+	stop := engine.heartbeatLocks([]string{"channel-1", "channel-2"}, "job-id", 10*time.Millisecond)
+	defer stop()
+
+	// The job is interrupted and the locks are no longer renewed
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "job not interrupted")
+	}
+	require.ErrorIs(t, context.Cause(ctx), errJobInterrupted)
+	time.Sleep(30 * time.Millisecond)
+}
+
+func TestSchedules(t *testing.T) {
+	setup := func(t *testing.T) (*engineTestHelper, *Engine, *Schedule) {
+		th := newEngineTestHelper(t)
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+
+		cfg := newValidEmptyConfig()
+		cfg.Users = []AddUser{{UserID: "user-1"}}
+
+		return th, engine, &Schedule{
+			Config: *cfg,
+			RunAt:  model.GetMillis() + time.Hour.Milliseconds(),
+		}
+	}
+
+	mockChannel := func(th *engineTestHelper, cfg Config) {
+		th.API.On("GetChannel", cfg.ChannelID).Return(&model.Channel{
+			Id:     cfg.ChannelID,
+			Type:   model.ChannelTypeOpen,
+			TeamId: "team-id",
+		}, nil)
+		th.API.On("HasPermissionToChannel", cfg.UserID, cfg.ChannelID, model.PermissionManagePublicChannelMembers).Return(true)
+	}
+
+	t.Run("schedules in the past should fail", func(t *testing.T) {
+		th, engine, schedule := setup(t)
+		defer th.finish()
+
+		schedule.RunAt = model.GetMillis() - time.Minute.Milliseconds()
+
+		_, err := engine.CreateSchedule(schedule)
+		require.NotNil(t, err)
+		th.API.AssertNotCalled(t, "GetChannel", mock.Anything)
+	})
+
+	t.Run("recurring schedules start a job on every run", func(t *testing.T) {
+		th, engine, schedule := setup(t)
+		defer th.finish()
+
+		mockChannel(th, schedule.Config)
+		schedule.IntervalHours = 24
+		schedule, err := engine.CreateSchedule(schedule)
+		require.Nil(t, err)
+		require.NotEmpty(t, schedule.ID)
+		firstRunAt := schedule.RunAt
+
+		// Not due yet
+		engine.runSchedules(firstRunAt - 1)
+		th.API.AssertNotCalled(t, "CreatePost", mock.Anything)
+
+		cfg := schedule.Config
+		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(cfg.ChannelID).Return(false)
+		th.KV.(*mocks.MockLockStore).EXPECT().Lock(cfg.ChannelID, gomock.Any()).Return(nil)
+		th.KV.(*mocks.MockLockStore).EXPECT().Unlock(cfg.ChannelID, gomock.Any()).Return(nil)
+		th.API.On("GetUser", cfg.UserID).Return(&model.User{Id: cfg.UserID, Username: "username"}, nil)
+		th.API.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+		th.API.On("PublishWebSocketEvent", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return()
+		th.API.On("UploadFile", mock.Anything, cfg.ChannelID, mock.AnythingOfType("string")).Return(&model.FileInfo{Id: "file-id"}, nil)
+		th.API.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: 1000}, nil)
+		th.API.On("GetUser", "user-1").Return(&model.User{Id: "user-1"}, nil)
+		th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"user-1"}).Return(model.ChannelMembers{}, nil)
+		th.API.On("GetTeamMember", "team-id", "user-1").Return(&model.TeamMember{}, nil)
+		th.API.On("AddUserToChannel", cfg.ChannelID, "user-1", cfg.UserID).Return(&model.ChannelMember{}, nil)
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		engine.SetOnFinish(func() {
+			wg.Done()
+		})
+
+		engine.runSchedules(firstRunAt + time.Minute.Milliseconds())
+		wg.Wait()
+
+		stored, getErr := th.Jobs.GetSchedule(schedule.ID)
+		require.NoError(t, getErr)
+		require.NotEmpty(t, stored.LastJobID)
+		require.Empty(t, stored.LastError)
+		require.Equal(t, firstRunAt+(24*time.Hour).Milliseconds(), stored.RunAt)
+
+		job, jobErr := th.Jobs.GetJob(stored.LastJobID)
+		require.NoError(t, jobErr)
+		require.Equal(t, JobStateFinished, job.State)
+		require.Equal(t, 1, job.Result.AddedUsers)
+	})
+
+	t.Run("single run schedules that can't start keep the error", func(t *testing.T) {
+		th, engine, schedule := setup(t)
+		defer th.finish()
+
+		mockChannel(th, schedule.Config)
+		schedule, err := engine.CreateSchedule(schedule)
+		require.Nil(t, err)
+
+		th.KV.(*mocks.MockLockStore).EXPECT().IsLocked(schedule.Config.ChannelID).Return(true)
+		th.API.On("LogWarn", "error starting scheduled job", "schedule_id", schedule.ID, "channel_id", schedule.Config.ChannelID, "err", mock.Anything).Return()
+
+		engine.runSchedules(schedule.RunAt)
+
+		stored, getErr := th.Jobs.GetSchedule(schedule.ID)
+		require.NoError(t, getErr)
+		require.Equal(t, int64(0), stored.RunAt)
+		require.Empty(t, stored.LastJobID)
+		require.Contains(t, stored.LastError, "already running")
+	})
+}
+
+func TestAddUsersConcurrently(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+	engine.SetSettings(Settings{Concurrency: 4})
+
+	cfg := newValidEmptyConfig()
+	cfg.channel = &model.Channel{Id: cfg.ChannelID, TeamId: "team-id"}
+	cfg.teamUsers = map[string]*model.User{}
+
+	expected := []UserResult{}
+	for i := 0; i < 20; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		cfg.Users = append(cfg.Users, AddUser{UserID: userID})
+		cfg.teamUsers[userID] = &model.User{Id: userID}
+		expected = append(expected, UserResult{Input: userID, UserID: userID, Outcome: OutcomeAdded})
+	}
+
+	th.API.On("GetChannelMembersByIds", cfg.ChannelID, mock.Anything).Return(model.ChannelMembers{}, nil)
+	th.API.On("AddUserToChannel", cfg.ChannelID, mock.Anything, cfg.UserID).Return(&model.ChannelMember{}, nil)
+
+	t.Run("results keep the order of the input", func(t *testing.T) {
+		results := engine.processUsers(context.Background(), cfg, cfg.Users)
+		require.Equal(t, expected, results)
+	})
+
+	t.Run("cancelled context stops dispatching users", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		results := engine.processUsers(ctx, cfg, cfg.Users)
+		require.Empty(t, results)
+	})
+}
+
+func TestAddExistingMembers(t *testing.T) {
+	setup := func(t *testing.T) (*engineTestHelper, *Engine, *Config) {
+		th := newEngineTestHelper(t)
+		engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+		engine.SetSettings(Settings{Concurrency: 1})
+
+		cfg := newValidEmptyConfig()
+		cfg.channel = &model.Channel{Id: cfg.ChannelID, TeamId: "team-id"}
+		cfg.teamUsers = map[string]*model.User{
+			"member":   {Id: "member"},
+			"new-user": {Id: "new-user"},
+		}
+		cfg.Users = []AddUser{{UserID: "member"}, {UserID: "new-user"}}
+
+		th.API.On("AddUserToChannel", cfg.ChannelID, "new-user", cfg.UserID).Return(&model.ChannelMember{}, nil).Once()
+		return th, engine, cfg
+	}
+
+	expected := []UserResult{
+		{Input: "member", UserID: "member", Outcome: OutcomeAlreadyMember},
+		{Input: "new-user", UserID: "new-user", Outcome: OutcomeAdded},
+	}
+
+	t.Run("prefetched channel members are not added again", func(t *testing.T) {
+		th, engine, cfg := setup(t)
+		defer th.finish()
+
+		th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"member", "new-user"}).Return(model.ChannelMembers{
+			{UserId: "member"},
+		}, nil)
+
+		results := engine.processUsers(context.Background(), cfg, cfg.Users)
+		require.Equal(t, expected, results)
+
+		result := bulkChannelAddResult{}
+		for _, r := range results {
+			result.add(r)
+		}
+		require.Equal(t, bulkChannelAddResult{AddedUsers: 1, AlreadyMember: 1}, result)
+	})
+
+	t.Run("channel membership is checked per user if the prefetch fails", func(t *testing.T) {
+		th, engine, cfg := setup(t)
+		defer th.finish()
+
+		th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"member", "new-user"}).Return(nil, &model.AppError{Message: "error"})
+		th.API.On("LogError", "error getting channel members", "trigger_user_id", cfg.UserID, "channel_id", cfg.ChannelID, "err", mock.Anything)
+		th.API.On("GetChannelMember", cfg.ChannelID, "member").Return(&model.ChannelMember{}, nil)
+		th.API.On("GetChannelMember", cfg.ChannelID, "new-user").Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+
+		results := engine.processUsers(context.Background(), cfg, cfg.Users)
+		require.Equal(t, expected, results)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("nil limiter doesn't wait", func(t *testing.T) {
+		limiter := newRateLimiter(0)
+		require.Nil(t, limiter)
+		require.NoError(t, limiter.Wait(context.Background()))
+	})
+
+	t.Run("waits once the burst is used", func(t *testing.T) {
+		limiter := newRateLimiter(100)
+
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			require.NoError(t, limiter.Wait(context.Background()))
+		}
+		require.Less(t, time.Since(start), 50*time.Millisecond)
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, limiter.Wait(context.Background()))
+		}
+		require.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		limiter := newRateLimiter(1)
+		require.NoError(t, limiter.Wait(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		require.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+
+		// The reserved operation is given back
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		require.Greater(t, limiter.tokens, -1.0)
+	})
+}
+
+func TestProcessUsersInterruptedByRateLimiter(t *testing.T) {
+	th := newEngineTestHelper(t)
+	defer th.finish()
+	engine := NewEngine(th.API, th.KV, th.Jobs, "bot-user-id")
+	engine.SetSettings(Settings{Concurrency: 1, RateLimit: 1})
+
+	cfg := newValidEmptyConfig()
+	cfg.channel = &model.Channel{Id: cfg.ChannelID, TeamId: "team-id"}
+	cfg.teamUsers = map[string]*model.User{
+		"user-1": {Id: "user-1"},
+		"user-2": {Id: "user-2"},
+	}
+	cfg.Users = []AddUser{{UserID: "user-1"}, {UserID: "user-2"}}
+
+	th.API.On("GetChannelMembersByIds", cfg.ChannelID, []string{"user-1", "user-2"}).Return(model.ChannelMembers{}, nil)
+	th.API.On("AddUserToChannel", cfg.ChannelID, "user-1", cfg.UserID).Return(&model.ChannelMember{}, nil).Once()
+
+	// The second user waits for a second, the job is stopped first
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results := engine.processUsers(ctx, cfg, cfg.Users)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Equal(t, []UserResult{{Input: "user-1", UserID: "user-1", Outcome: OutcomeAdded}}, results)
+}
+
+func TestReportFilename(t *testing.T) {
+	require.Equal(t, "bulk-add-report-job-id.csv", ReportFilename(&Job{ID: "job-id", Operation: OperationAdd}, "csv"))
+	require.Equal(t, "bulk-remove-report-job-id.json", ReportFilename(&Job{ID: "job-id", Operation: OperationRemove}, "json"))
+	require.Equal(t, "bulk-sync-report-job-id.csv", ReportFilename(&Job{ID: "job-id", Operation: OperationSync}, "csv"))
+	require.Equal(t, "bulk-undo-report-job-id.csv", ReportFilename(&Job{ID: "job-id", Operation: OperationUndo}, "csv"))
+}
+
+func TestReportCSV(t *testing.T) {
+	report := Report{
+		{Input: "user-1", UserID: "user-1", ChannelID: "channel-1", Outcome: OutcomeAdded, AddedToTeam: true},
+		{Input: "missing", Outcome: OutcomeError, Error: "error getting user by username: not found"},
+	}
+
+	data, err := report.CSV()
+	require.NoError(t, err)
+	require.Equal(t, "input,user_id,channel_id,outcome,added_to_team,removed_from_team,error\n"+
+		"user-1,user-1,channel-1,added,true,false,\n"+
+		"missing,,,error,false,false,error getting user by username: not found\n", string(data))
+}
+
+// BenchmarkProcessJobUsers reports the lookup requests per added user, calls to the mutation APIs
+// excluded. Resolving every user one by one takes 2 lookups per user ID and 3 per username. User IDs
+// in large teams still take 2 lookups per user since the plugin API can't get users by IDs in batch.
+func BenchmarkProcessJobUsers(b *testing.B) {
+	for _, bc := range []struct {
+		name       string
+		teamSize   int
+		byUsername bool
+	}{
+		{name: "user ids in small team", teamSize: 1000},
+		{name: "user ids in large team", teamSize: 1000000},
+		{name: "usernames in large team", teamSize: 1000000, byUsername: true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			benchmarkProcessJobUsers(b, 1000, bc.teamSize, bc.byUsername)
+		})
+	}
+}
+
+func benchmarkProcessJobUsers(b *testing.B, userCount, teamSize int, byUsername bool) {
+	api := &plugintest.API{}
+	engine := NewEngine(api, nil, newMemoryJobStore(), "bot-user-id")
+
+	newUser := func(i int) *model.User {
+		return &model.User{Id: fmt.Sprintf("user-%d", i), Username: fmt.Sprintf("username-%d", i)}
+	}
+
+	users := make([]AddUser, userCount)
+	for i := range users {
+		users[i] = AddUser{UserID: newUser(i).Id}
+		if byUsername {
+			users[i] = AddUser{Username: newUser(i).Username}
+		}
+	}
+
+	api.On("GetTeamStats", "team-id").Return(&model.TeamStats{TotalMemberCount: int64(teamSize)}, nil)
+	api.On("GetUsersInTeam", "team-id", mock.Anything, mock.Anything).Return(func(_ string, page, perPage int) []*model.User {
+		teamUsers := []*model.User{}
+		for i := page * perPage; i < (page+1)*perPage && i < teamSize; i++ {
+			teamUsers = append(teamUsers, newUser(i))
+		}
+		return teamUsers
+	}, nil)
+	api.On("GetUser", mock.Anything).Return(func(userID string) *model.User {
+		return &model.User{Id: userID}
+	}, nil)
+	api.On("GetUsersByUsernames", mock.Anything).Return(func(usernames []string) []*model.User {
+		found := make([]*model.User, 0, len(usernames))
+		for _, username := range usernames {
+			found = append(found, &model.User{Id: "id-" + username, Username: username})
+		}
+		return found
+	}, nil)
+	api.On("GetChannelMembersByIds", "channel-id", mock.Anything).Return(model.ChannelMembers{}, nil)
+	api.On("GetTeamMember", "team-id", mock.Anything).Return(&model.TeamMember{}, nil)
+	api.On("AddUserToChannel", "channel-id", mock.Anything, "user-id").Return(&model.ChannelMember{}, nil)
+	api.On("PublishWebSocketEvent", WebSocketEventJobProgress, mock.Anything, mock.Anything).Return()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		channel := &model.Channel{Id: "channel-id", TeamId: "team-id"}
+		config := &Config{
+			ChannelID:  "channel-id",
+			ChannelIDs: []string{"channel-id"},
+			channel:    channel,
+			channels:   []*model.Channel{channel},
+			UserID:     "user-id",
+			Users:      users,
+		}
+		job := &Job{ID: model.NewId()}
+		engine.processJobUsers(context.Background(), config, job, nil, &progressTracker{})
+		require.Equal(b, userCount, job.Result.AddedUsers)
+	}
+	b.StopTimer()
+
+	// Every user must be resolved and added, otherwise the benchmark measures an empty loop
+	lookups, adds := 0, 0
+	for _, call := range api.Calls {
+		switch call.Method {
+		case "AddUserToChannel":
+			adds++
+		case "PublishWebSocketEvent":
+		default:
+			lookups++
+		}
+	}
+	require.Equal(b, b.N*userCount, adds)
+	require.NotZero(b, lookups)
+	b.ReportMetric(float64(lookups)/float64(b.N*userCount), "lookups/user")
+}
+
+// memoryKVStore is an in-memory KVStore supporting atomic writes, used to test the job store
+type memoryKVStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	ttls   map[string]int64
+}
+
+func newMemoryKVStore() *memoryKVStore {
+	return &memoryKVStore{values: map[string][]byte{}, ttls: map[string]int64{}}
+}
+
+func (s *memoryKVStore) Load(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil, kvstore.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *memoryKVStore) Store(key string, data []byte) error {
+	return s.StoreTTL(key, data, 0)
+}
+
+func (s *memoryKVStore) StoreTTL(key string, data []byte, ttlSeconds int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = data
+	s.ttls[key] = ttlSeconds
+	return nil
+}
+
+func (s *memoryKVStore) StoreWithOptions(key string, value []byte, opts model.PluginKVSetOptions) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, exists := s.values[key]
+	if opts.Atomic && ((opts.OldValue == nil && exists) || (opts.OldValue != nil && !bytes.Equal(current, opts.OldValue))) {
+		return false, nil
+	}
+	if value == nil {
+		delete(s.values, key)
+		return true, nil
+	}
+	s.values[key] = value
+	s.ttls[key] = opts.ExpireInSeconds
+	return true, nil
+}
+
+func (s *memoryKVStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memoryKVStore) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.values[key]
+	return ok
+}
+
+func TestJobStore(t *testing.T) {
+	newTestJob := func(id, userID string, createAt int64, channelIDs ...string) *Job {
+		return &Job{ID: id, UserID: userID, ChannelID: channelIDs[0], ChannelIDs: channelIDs, State: JobStateRunning, CreateAt: createAt}
+	}
+
+	jobIDs := func(jobs []*Job) []string {
+		ids := []string{}
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return ids
+	}
+
+	setup := func(t *testing.T) (*memoryKVStore, JobStore) {
+		kv := newMemoryKVStore()
+		store := NewJobStore(kv)
+		require.NoError(t, store.CreateJob(newTestJob("job-1", "user-1", 1, "channel-1")))
+		require.NoError(t, store.CreateJob(newTestJob("job-2", "user-2", 2, "channel-1", "channel-2")))
+		require.NoError(t, store.CreateJob(newTestJob("job-3", "user-1", 3, "channel-2")))
+		return kv, store
+	}
+
+	t.Run("jobs should be listed from the indexes, most recent first", func(t *testing.T) {
+		_, store := setup(t)
+
+		for _, tc := range []struct {
+			opts     JobListOptions
+			expected []string
+		}{
+			{JobListOptions{}, []string{"job-3", "job-2", "job-1"}},
+			{JobListOptions{ChannelID: "channel-1"}, []string{"job-2", "job-1"}},
+			{JobListOptions{ChannelID: "channel-2", UserID: "user-1"}, []string{"job-3"}},
+			{JobListOptions{UserID: "user-1"}, []string{"job-3", "job-1"}},
+			{JobListOptions{PerPage: 2}, []string{"job-3", "job-2"}},
+			{JobListOptions{Page: 1, PerPage: 2}, []string{"job-1"}},
+			{JobListOptions{Page: 2, PerPage: 2}, []string{}},
+		} {
+			jobs, err := store.ListJobs(tc.opts)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, jobIDs(jobs), "%+v", tc.opts)
+		}
+	})
+
+	t.Run("finished jobs should expire and leave the active jobs", func(t *testing.T) {
+		kv, store := setup(t)
+
+		job, err := store.GetJob("job-2")
+		require.NoError(t, err)
+		job.State = JobStateFinished
+		require.NoError(t, store.SaveJob(job, time.Hour))
+		require.Equal(t, int64(3600), kv.ttls[getJobKey("job-2")])
+
+		active, err := store.ListActiveJobs()
+		require.NoError(t, err)
+		require.Equal(t, []string{"job-3", "job-1"}, jobIDs(active))
+	})
+
+	t.Run("report chunks should expire", func(t *testing.T) {
+		kv, store := setup(t)
+
+		require.NoError(t, store.SaveJobReportChunk("job-1", 0, []UserResult{{Input: "user-1"}}, time.Hour))
+		require.Equal(t, int64(3600), kv.ttls[getJobReportChunkKey("job-1", 0)])
+	})
+
+	t.Run("expired jobs should be removed from the indexes", func(t *testing.T) {
+		kv, store := setup(t)
+
+		require.NoError(t, kv.Delete(getJobKey("job-2")))
+
+		jobs, err := store.ListJobs(JobListOptions{ChannelID: "channel-1"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"job-1"}, jobIDs(jobs))
+
+		var ids []string
+		require.NoError(t, json.Unmarshal(kv.values[getChannelJobsIndexKey("channel-1")], &ids))
+		require.Equal(t, []string{"job-1"}, ids)
+	})
+
+	t.Run("concurrent jobs should all be indexed", func(t *testing.T) {
+		kv := newMemoryKVStore()
+		store := NewJobStore(kv)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- store.CreateJob(newTestJob(fmt.Sprintf("job-%d", i), "user-1", int64(i), "channel-1"))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		jobs, err := store.ListJobs(JobListOptions{ChannelID: "channel-1"})
+		require.NoError(t, err)
+		require.Len(t, jobs, 5)
+	})
+
+	t.Run("schedules should be listed from the index, next to run first", func(t *testing.T) {
+		kv := newMemoryKVStore()
+		store := NewJobStore(kv)
+
+		require.NoError(t, store.CreateSchedule(&Schedule{ID: "schedule-1", RunAt: 3}))
+		require.NoError(t, store.CreateSchedule(&Schedule{ID: "schedule-2", RunAt: 1}))
+		require.NoError(t, store.CreateSchedule(&Schedule{ID: "schedule-3", RunAt: 2}))
+		require.NoError(t, store.DeleteSchedule("schedule-3"))
+		require.NoError(t, kv.Delete(getScheduleKey("schedule-1")))
+
+		schedules, err := store.ListSchedules()
+		require.NoError(t, err)
+		require.Len(t, schedules, 1)
+		require.Equal(t, "schedule-2", schedules[0].ID)
+
+		var ids []string
+		require.NoError(t, json.Unmarshal(kv.values[schedulesIndexKey], &ids))
+		require.Equal(t, []string{"schedule-2"}, ids)
+	})
 }
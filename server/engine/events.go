@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"encoding/json"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// WebSocket events sent to the user that triggered a job. The server prefixes them with
+// "custom_com.mattermost.bulk-invite_".
+const (
+	// WebSocketEventJobStarted sent when a job starts or is resumed
+	WebSocketEventJobStarted = "job_started"
+
+	// WebSocketEventJobProgress sent after each batch of processed users, and when the job is
+	// interrupted by a plugin shutdown
+	WebSocketEventJobProgress = "job_progress"
+
+	// WebSocketEventJobUserFailed sent for each user the job failed to process, with the user result
+	WebSocketEventJobUserFailed = "job_user_failed"
+
+	// WebSocketEventJobFinished sent when the job finishes, is cancelled or fails
+	WebSocketEventJobFinished = "job_finished"
+)
+
+// JobEvent is the payload of the job WebSocket events. The fields are sent with their JSON names.
+type JobEvent struct {
+	JobID     string    `json:"job_id"`
+	Operation Operation `json:"operation"`
+
+	// ChannelID the first channel of the job, where the progress is posted
+	ChannelID string `json:"channel_id"`
+
+	State JobState `json:"state"`
+
+	// TotalUsers the number of users to process, counting each user once per channel
+	TotalUsers int `json:"total_users"`
+
+	// ProcessedUsers the number of users processed so far
+	ProcessedUsers int `json:"processed_users"`
+
+	// Result the per-outcome counters of the processed users
+	Result bulkChannelAddResult `json:"result"`
+
+	// Error the reason of the failure on failed jobs
+	Error string `json:"error,omitempty"`
+
+	// User the result of the failed user on job_user_failed events
+	User *UserResult `json:"user,omitempty"`
+}
+
+func newJobEvent(job *Job) JobEvent {
+	return JobEvent{
+		JobID:          job.ID,
+		Operation:      job.Operation,
+		ChannelID:      job.ChannelID,
+		State:          job.State,
+		TotalUsers:     job.TotalUsers,
+		ProcessedUsers: job.ProcessedUsers,
+		Result:         job.Result,
+		Error:          job.Error,
+	}
+}
+
+// publishJobEvent sends the event to the user that triggered the job. The payload is JSON round-tripped
+// into a map[string]any so it only holds JSON types: nested structs, like the result counters, are sent
+// as nested maps and numbers as float64.
+func (e *Engine) publishJobEvent(job *Job, event string, payload JobEvent) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		e.API.LogError("error marshaling job event", "job_id", job.ID, "event", event, "err", err.Error())
+		return
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		e.API.LogError("error unmarshaling job event", "job_id", job.ID, "event", event, "err", err.Error())
+		return
+	}
+
+	e.API.PublishWebSocketEvent(event, fields, &model.WebsocketBroadcast{
+		UserId:    job.UserID,
+		ChannelId: job.ChannelID,
+	})
+}
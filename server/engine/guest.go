@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+)
+
+// GuestPolicy how bulk add and sync operations treat guest users
+type GuestPolicy string
+
+const (
+	// GuestPolicySkip guests are never added, the default
+	GuestPolicySkip GuestPolicy = "skip"
+
+	// GuestPolicyAllowTeamMembers guests are added if they already belong to the team of the channel
+	GuestPolicyAllowTeamMembers GuestPolicy = "allow_team_members"
+
+	// GuestPolicyAllowAndAddToTeam guests are added, and added to the team of the channel if needed
+	GuestPolicyAllowAndAddToTeam GuestPolicy = "allow_and_add_to_team"
+)
+
+// IsValid returns true for the known policies
+func (p GuestPolicy) IsValid() bool {
+	switch p {
+	case GuestPolicySkip, GuestPolicyAllowTeamMembers, GuestPolicyAllowAndAddToTeam:
+		return true
+	}
+	return false
+}
+
+// resolveGuestPolicy sets the guest policy of operations adding users, the one configured in the
+// plugin if the request didn't set it. Guests are skipped when guest accounts are disabled in the
+// server, since they can't log in.
+func (e *Engine) resolveGuestPolicy(config *Config) *perror.PError {
+	config.guestPolicyFromSettings = false
+	if config.isRemove() || config.isUndo() {
+		config.GuestPolicy = ""
+		return nil
+	}
+
+	if config.GuestPolicy == "" {
+		settings, _ := e.getSettings()
+		config.GuestPolicy = settings.GuestPolicy
+		config.guestPolicyFromSettings = true
+	}
+	if config.GuestPolicy == "" {
+		config.GuestPolicy = GuestPolicySkip
+	}
+
+	if !config.GuestPolicy.IsValid() {
+		return perror.NewPError(fmt.Errorf("invalid guest policy %s", config.GuestPolicy), "Invalid guest policy")
+	}
+
+	if config.GuestPolicy != GuestPolicySkip && !e.guestAccountsEnabled() {
+		config.GuestPolicy = GuestPolicySkip
+	}
+
+	return nil
+}
+
+// guestAccountsEnabled returns true if guest accounts are enabled in the server
+func (e *Engine) guestAccountsEnabled() bool {
+	serverConfig := e.API.GetConfig()
+	if serverConfig == nil || serverConfig.GuestAccountsSettings.Enable == nil {
+		return false
+	}
+
+	return *serverConfig.GuestAccountsSettings.Enable
+}
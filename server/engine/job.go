@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+type JobState string
+
+const (
+	// JobStateQueued the job has been created but has not started processing users yet
+	JobStateQueued JobState = "queued"
+
+	// JobStateRunning the job is processing users
+	JobStateRunning JobState = "running"
+
+	// JobStateFinished the job processed all users
+	JobStateFinished JobState = "finished"
+
+	// JobStateFailed the job could not complete due to an error
+	JobStateFailed JobState = "failed"
+
+	// JobStateCancelled the job was stopped by a user before processing all users
+	JobStateCancelled JobState = "cancelled"
+
+	// JobStateInterrupted the job was stopped by a plugin shutdown or lost a channel lock, and is
+	// pending to be resumed
+	JobStateInterrupted JobState = "interrupted"
+)
+
+// Job is the persisted record of a bulk operation
+type Job struct {
+	ID string `json:"id"`
+
+	// Operation the operation applied to the users
+	Operation Operation `json:"operation"`
+
+	// ChannelID the channel the users are being added to or removed from, the first of ChannelIDs on
+	// jobs targeting multiple channels
+	ChannelID string `json:"channel_id"`
+
+	// ChannelIDs the channels targeted by the job
+	ChannelIDs []string `json:"channel_ids"`
+
+	// UserID the user that triggered the job
+	UserID string `json:"user_id"`
+
+	// SourceChannelID the channel the users were copied from, if any
+	SourceChannelID string `json:"source_channel_id,omitempty"`
+
+	// SourceGroupID the user group the users were copied from, if any
+	SourceGroupID string `json:"source_group_id,omitempty"`
+
+	// SourceTeamID the team the users were copied from, if any
+	SourceTeamID string `json:"source_team_id,omitempty"`
+
+	// AddToTeam whether users not belonging to the team are added to it
+	AddToTeam bool `json:"add_to_team"`
+
+	// GuestPolicy how guests are treated by jobs adding users
+	GuestPolicy GuestPolicy `json:"guest_policy,omitempty"`
+
+	// RemoveExtras whether sync jobs remove the channel members that are not in the list
+	RemoveExtras bool `json:"remove_extras,omitempty"`
+
+	// SyncDiff the difference between the channel members and the list of sync jobs when they
+	// were started
+	SyncDiff *SyncDiff `json:"sync_diff,omitempty"`
+
+	// UndoJobID the job that removed the memberships created by this job, if it was undone
+	UndoJobID string `json:"undo_job_id,omitempty"`
+
+	// UndoOfJobID the job whose memberships are removed by this undo job
+	UndoOfJobID string `json:"undo_of_job_id,omitempty"`
+
+	// TotalUsers the number of users provided as input times the number of channels
+	TotalUsers int `json:"total_users"`
+
+	// ProcessedUsers the number of users already processed, counting each user once per channel
+	ProcessedUsers int `json:"processed_users"`
+
+	State JobState `json:"state"`
+
+	// Error the reason of the failure when State is JobStateFailed
+	Error string `json:"error,omitempty"`
+
+	// Result the per-outcome counters of the job
+	Result bulkChannelAddResult `json:"result"`
+
+	// ChannelResults the per-outcome counters of each channel of the job
+	ChannelResults map[string]bulkChannelAddResult `json:"channel_results,omitempty"`
+
+	// ProgressPostID the post of the first channel showing the progress of the job, updated while it runs
+	ProgressPostID string `json:"progress_post_id,omitempty"`
+
+	CreateAt int64 `json:"create_at"`
+	StartAt  int64 `json:"start_at,omitempty"`
+	UpdateAt int64 `json:"update_at"`
+	FinishAt int64 `json:"finish_at,omitempty"`
+}
+
+func newJob(config *Config) *Job {
+	now := model.GetMillis()
+	return &Job{
+		ID:              model.NewId(),
+		Operation:       config.Operation,
+		ChannelID:       config.ChannelID,
+		ChannelIDs:      config.ChannelIDs,
+		UserID:          config.UserID,
+		SourceChannelID: config.SourceChannelID,
+		SourceGroupID:   config.SourceGroupID,
+		SourceTeamID:    config.SourceTeamID,
+		AddToTeam:       config.AddToTeam,
+		GuestPolicy:     config.GuestPolicy,
+		RemoveExtras:    config.RemoveExtras,
+		SyncDiff:        config.syncDiff,
+		UndoOfJobID:     config.UndoOfJobID,
+		TotalUsers:      len(config.Users) * len(config.ChannelIDs),
+		State:           JobStateQueued,
+		CreateAt:        now,
+		UpdateAt:        now,
+	}
+}
+
+// TargetsChannel returns true if the channel is one of the channels targeted by the job
+func (j *Job) TargetsChannel(channelID string) bool {
+	for _, targetChannelID := range j.ChannelIDs {
+		if targetChannelID == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinished returns true if the job will not process any more users
+func (j *Job) IsFinished() bool {
+	return j.State == JobStateFinished || j.State == JobStateFailed || j.State == JobStateCancelled
+}
@@ -0,0 +1,524 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	jobKeyPrefix      = "job_"
+	scheduleKeyPrefix = "schedule_"
+
+	// schedulesIndexKey the index of the IDs of all the schedules
+	schedulesIndexKey = "schedules_index"
+
+	// allJobsIndexKey, activeJobsIndexKey the indexes of all the jobs and of the jobs that didn't
+	// finish yet, most recent first
+	allJobsIndexKey    = "jobs_all"
+	activeJobsIndexKey = "jobs_active"
+
+	// maxJobIndexSize the maximum number of jobs kept in the indexes of all, channel and user jobs.
+	// Older jobs are dropped from the indexes, the active jobs index is never trimmed.
+	maxJobIndexSize = 1000
+
+	// indexUpdateAttempts the times an index update is retried when another node updates it concurrently
+	indexUpdateAttempts = 10
+
+	// cancelRequestTTL how long a cancel request is kept, it only matters while the job is running
+	cancelRequestTTL = 24 * time.Hour
+
+	// claimTTL how long a job claim is kept, it only needs to outlive the nodes competing for it
+	claimTTL = time.Hour
+)
+
+var (
+	cancelRequestedValue = []byte("1")
+	claimedValue         = []byte("1")
+)
+
+func getJobKey(jobID string) string {
+	return jobKeyPrefix + jobID
+}
+
+func getChannelJobsIndexKey(channelID string) string {
+	return "jobs_channel_" + channelID
+}
+
+func getUserJobsIndexKey(userID string) string {
+	return "jobs_user_" + userID
+}
+
+func getJobCancelKey(jobID string) string {
+	return "cancel_" + jobID
+}
+
+// getJobClaimKey the claim is bound to the last update of the job, so every interruption can be
+// claimed again
+func getJobClaimKey(job *Job) string {
+	return fmt.Sprintf("claim_%s_%d", job.ID, job.UpdateAt)
+}
+
+func getJobReportChunkKey(jobID string, chunk int) string {
+	return fmt.Sprintf("report_%s_%d", jobID, chunk)
+}
+
+func getJobInputKey(jobID string) string {
+	return "input_" + jobID
+}
+
+func getScheduleKey(scheduleID string) string {
+	return scheduleKeyPrefix + scheduleID
+}
+
+// getScheduleRunClaimKey the claim is bound to the run time, so every run of a recurring schedule
+// can be claimed
+func getScheduleRunClaimKey(schedule *Schedule) string {
+	return fmt.Sprintf("claim_schedule_%s_%d", schedule.ID, schedule.RunAt)
+}
+
+// JobListOptions filters and pages the listed jobs
+type JobListOptions struct {
+	// ChannelID only lists the jobs targeting the channel
+	ChannelID string
+
+	// UserID only lists the jobs triggered by the user
+	UserID string
+
+	Page    int
+	PerPage int
+}
+
+// JobStore persists the bulk operation jobs
+type JobStore interface {
+	// CreateJob stores a new job and adds it to the job indexes
+	CreateJob(job *Job) error
+	// SaveJob stores the job, finished jobs expire after the ttl and are removed from the active jobs
+	SaveJob(job *Job, ttl time.Duration) error
+	GetJob(jobID string) (*Job, error)
+	// ListJobs returns a page of the jobs matching the options, most recent first
+	ListJobs(opts JobListOptions) ([]*Job, error)
+	// ListActiveJobs returns the jobs that didn't finish yet
+	ListActiveJobs() ([]*Job, error)
+
+	// ClaimJob atomically claims the job as of its last update, only the first caller succeeds.
+	// Used to ensure a single node takes over an interrupted job.
+	ClaimJob(job *Job) (bool, error)
+	// ReleaseJobClaim removes the claim of a job that couldn't be taken over, so it can be claimed again
+	ReleaseJobClaim(job *Job) error
+
+	// SaveJobInput stores the users to process by a job, used to resume it
+	SaveJobInput(jobID string, users []AddUser) error
+	GetJobInput(jobID string) ([]AddUser, error)
+	DeleteJobInput(jobID string) error
+
+	// SaveJobReportChunk stores a consecutive chunk of the per-user results of a job, expiring after the ttl
+	SaveJobReportChunk(jobID string, chunk int, results []UserResult, ttl time.Duration) error
+	// GetJobReport returns all the stored per-user results of a job
+	GetJobReport(jobID string) (Report, error)
+
+	// RequestJobCancel flags a job to be cancelled by the node running it
+	RequestJobCancel(jobID string) error
+	IsJobCancelRequested(jobID string) (bool, error)
+
+	// CreateSchedule stores a new schedule and adds it to the schedules index
+	CreateSchedule(schedule *Schedule) error
+	SaveSchedule(schedule *Schedule) error
+	GetSchedule(scheduleID string) (*Schedule, error)
+	ListSchedules() ([]*Schedule, error)
+	DeleteSchedule(scheduleID string) error
+
+	// ClaimScheduleRun atomically claims the next run of the schedule, only the first caller
+	// succeeds. Used to ensure a scheduled operation is started once.
+	ClaimScheduleRun(schedule *Schedule) (bool, error)
+}
+
+type jobStore struct {
+	store kvstore.KVStore
+}
+
+func NewJobStore(store kvstore.KVStore) JobStore {
+	return &jobStore{
+		store: store,
+	}
+}
+
+func (s *jobStore) CreateJob(job *Job) error {
+	if err := s.SaveJob(job, 0); err != nil {
+		return err
+	}
+
+	indexKeys := []string{allJobsIndexKey, getUserJobsIndexKey(job.UserID)}
+	for _, channelID := range job.ChannelIDs {
+		indexKeys = append(indexKeys, getChannelJobsIndexKey(channelID))
+	}
+	for _, key := range indexKeys {
+		if err := s.updateIndex(key, func(ids []string) ([]string, bool) {
+			return prependID(ids, job.ID, maxJobIndexSize), true
+		}); err != nil {
+			return fmt.Errorf("error indexing job: %w", err)
+		}
+	}
+
+	if !job.IsFinished() {
+		if err := s.updateIndex(activeJobsIndexKey, func(ids []string) ([]string, bool) {
+			return prependID(ids, job.ID, 0), true
+		}); err != nil {
+			return fmt.Errorf("error indexing active job: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *jobStore) SaveJob(job *Job, ttl time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("error marshaling job: %w", err)
+	}
+
+	if !job.IsFinished() {
+		return s.store.Store(getJobKey(job.ID), data)
+	}
+
+	if err := s.store.StoreTTL(getJobKey(job.ID), data, int64(ttl/time.Second)); err != nil {
+		return err
+	}
+
+	return s.updateIndex(activeJobsIndexKey, func(ids []string) ([]string, bool) {
+		return removeIDs(ids, map[string]bool{job.ID: true})
+	})
+}
+
+func (s *jobStore) ClaimJob(job *Job) (bool, error) {
+	return s.store.StoreWithOptions(getJobClaimKey(job), claimedValue, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(claimTTL / time.Second),
+	})
+}
+
+func (s *jobStore) ReleaseJobClaim(job *Job) error {
+	return s.store.Delete(getJobClaimKey(job))
+}
+
+func (s *jobStore) GetJob(jobID string) (*Job, error) {
+	data, err := s.store.Load(getJobKey(jobID))
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("error unmarshaling job: %w", err)
+	}
+
+	return &job, nil
+}
+
+func (s *jobStore) ListJobs(opts JobListOptions) ([]*Job, error) {
+	indexKey := allJobsIndexKey
+	switch {
+	case opts.ChannelID != "":
+		indexKey = getChannelJobsIndexKey(opts.ChannelID)
+	case opts.UserID != "":
+		indexKey = getUserJobsIndexKey(opts.UserID)
+	}
+
+	// The channel index holds the jobs of every user
+	filter := func(job *Job) bool {
+		return opts.UserID == "" || job.UserID == opts.UserID
+	}
+
+	return s.listIndexJobs(indexKey, filter, opts.Page*opts.PerPage, opts.PerPage)
+}
+
+func (s *jobStore) ListActiveJobs() ([]*Job, error) {
+	return s.listIndexJobs(activeJobsIndexKey, func(*Job) bool { return true }, 0, 0)
+}
+
+// listIndexJobs loads the jobs of the index matching the filter, skipping the first offset ones and
+// returning up to limit jobs, or all of them if limit is 0. Expired jobs are removed from the index.
+func (s *jobStore) listIndexJobs(indexKey string, filter func(*Job) bool, offset, limit int) ([]*Job, error) {
+	ids, err := s.loadIndex(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading job index: %w", err)
+	}
+
+	jobs := []*Job{}
+	expired := map[string]bool{}
+	for _, id := range ids {
+		if limit > 0 && len(jobs) == limit {
+			break
+		}
+
+		job, err := s.GetJob(id)
+		if errors.Is(err, kvstore.ErrNotFound) {
+			expired[id] = true
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting job %s: %w", id, err)
+		}
+
+		if !filter(job) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	if len(expired) > 0 {
+		if err := s.updateIndex(indexKey, func(ids []string) ([]string, bool) {
+			return removeIDs(ids, expired)
+		}); err != nil {
+			return nil, fmt.Errorf("error removing expired jobs from index: %w", err)
+		}
+	}
+
+	return jobs, nil
+}
+
+// loadIndex returns the IDs stored in an index, empty if the index doesn't exist
+func (s *jobStore) loadIndex(key string) ([]string, error) {
+	ids, _, err := s.loadIndexData(key)
+	return ids, err
+}
+
+func (s *jobStore) loadIndexData(key string) ([]string, []byte, error) {
+	data, err := s.store.Load(key)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return []string{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling index %s: %w", key, err)
+	}
+
+	return ids, data, nil
+}
+
+// updateIndex atomically replaces the IDs of an index with the result of update, retrying if
+// another node updated the index in between. update returns false if the index doesn't change.
+func (s *jobStore) updateIndex(key string, update func(ids []string) ([]string, bool)) error {
+	for attempt := 0; attempt < indexUpdateAttempts; attempt++ {
+		ids, current, err := s.loadIndexData(key)
+		if err != nil {
+			return err
+		}
+
+		updated, changed := update(ids)
+		if !changed {
+			return nil
+		}
+
+		data, err := json.Marshal(updated)
+		if err != nil {
+			return fmt.Errorf("error marshaling index %s: %w", key, err)
+		}
+
+		stored, err := s.store.StoreWithOptions(key, data, model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: current,
+		})
+		if err != nil {
+			return err
+		}
+		if stored {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("error updating index %s: too many concurrent updates", key)
+}
+
+// prependID adds the ID at the beginning of the index, dropping the last IDs over maxSize, if any
+func prependID(ids []string, id string, maxSize int) []string {
+	ids = append([]string{id}, ids...)
+	if maxSize > 0 && len(ids) > maxSize {
+		ids = ids[:maxSize]
+	}
+	return ids
+}
+
+// removeIDs removes the IDs from the index, returning false if none of them were in it
+func removeIDs(ids []string, remove map[string]bool) ([]string, bool) {
+	kept := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !remove[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept, len(kept) != len(ids)
+}
+
+func (s *jobStore) RequestJobCancel(jobID string) error {
+	return s.store.StoreTTL(getJobCancelKey(jobID), cancelRequestedValue, int64(cancelRequestTTL/time.Second))
+}
+
+func (s *jobStore) IsJobCancelRequested(jobID string) (bool, error) {
+	_, err := s.store.Load(getJobCancelKey(jobID))
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *jobStore) SaveJobInput(jobID string, users []AddUser) error {
+	data, err := json.Marshal(users)
+	if err != nil {
+		return fmt.Errorf("error marshaling job input: %w", err)
+	}
+
+	return s.store.Store(getJobInputKey(jobID), data)
+}
+
+func (s *jobStore) GetJobInput(jobID string) ([]AddUser, error) {
+	data, err := s.store.Load(getJobInputKey(jobID))
+	if err != nil {
+		return nil, err
+	}
+
+	var users []AddUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("error unmarshaling job input: %w", err)
+	}
+
+	return users, nil
+}
+
+func (s *jobStore) DeleteJobInput(jobID string) error {
+	return s.store.Delete(getJobInputKey(jobID))
+}
+
+func (s *jobStore) SaveJobReportChunk(jobID string, chunk int, results []UserResult, ttl time.Duration) error {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("error marshaling job report: %w", err)
+	}
+
+	return s.store.StoreTTL(getJobReportChunkKey(jobID, chunk), data, int64(ttl/time.Second))
+}
+
+func (s *jobStore) GetJobReport(jobID string) (Report, error) {
+	report := Report{}
+	for chunk := 0; ; chunk++ {
+		data, err := s.store.Load(getJobReportChunkKey(jobID, chunk))
+		if errors.Is(err, kvstore.ErrNotFound) {
+			return report, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var results []UserResult
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, fmt.Errorf("error unmarshaling job report: %w", err)
+		}
+		report = append(report, results...)
+	}
+}
+
+func (s *jobStore) CreateSchedule(schedule *Schedule) error {
+	if err := s.SaveSchedule(schedule); err != nil {
+		return err
+	}
+
+	return s.updateIndex(schedulesIndexKey, func(ids []string) ([]string, bool) {
+		return append(ids, schedule.ID), true
+	})
+}
+
+func (s *jobStore) SaveSchedule(schedule *Schedule) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("error marshaling schedule: %w", err)
+	}
+
+	return s.store.Store(getScheduleKey(schedule.ID), data)
+}
+
+func (s *jobStore) GetSchedule(scheduleID string) (*Schedule, error) {
+	data, err := s.store.Load(getScheduleKey(scheduleID))
+	if err != nil {
+		return nil, err
+	}
+
+	var schedule Schedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("error unmarshaling schedule: %w", err)
+	}
+
+	return &schedule, nil
+}
+
+// ListSchedules returns all the schedules of the index, next to run first. Schedules not found are
+// removed from the index.
+func (s *jobStore) ListSchedules() ([]*Schedule, error) {
+	ids, err := s.loadIndex(schedulesIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading schedules index: %w", err)
+	}
+
+	schedules := []*Schedule{}
+	missing := map[string]bool{}
+	for _, id := range ids {
+		schedule, err := s.GetSchedule(id)
+		if errors.Is(err, kvstore.ErrNotFound) {
+			missing[id] = true
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting schedule %s: %w", id, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if len(missing) > 0 {
+		if err := s.updateIndex(schedulesIndexKey, func(ids []string) ([]string, bool) {
+			return removeIDs(ids, missing)
+		}); err != nil {
+			return nil, fmt.Errorf("error removing missing schedules from index: %w", err)
+		}
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].RunAt < schedules[j].RunAt
+	})
+
+	return schedules, nil
+}
+
+func (s *jobStore) DeleteSchedule(scheduleID string) error {
+	if err := s.store.Delete(getScheduleKey(scheduleID)); err != nil {
+		return err
+	}
+
+	return s.updateIndex(schedulesIndexKey, func(ids []string) ([]string, bool) {
+		return removeIDs(ids, map[string]bool{scheduleID: true})
+	})
+}
+
+func (s *jobStore) ClaimScheduleRun(schedule *Schedule) (bool, error) {
+	return s.store.StoreWithOptions(getScheduleRunClaimKey(schedule), claimedValue, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(claimTTL / time.Second),
+	})
+}
@@ -9,64 +9,458 @@ import (
 type AddUser struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
+	Email    string `json:"email"`
+
+	// Remove the user is removed from the channel, set by sync jobs on the members not in the list
+	Remove bool `json:"remove,omitempty"`
+}
+
+// Identifier returns the field used to identify the user, user_id takes preference over username
+// and username over email
+func (u AddUser) Identifier() string {
+	switch {
+	case u.UserID != "":
+		return u.UserID
+	case u.Username != "":
+		return u.Username
+	}
+	return u.Email
+}
+
+// Operation the bulk operation applied to the users of a job
+type Operation string
+
+const (
+	OperationAdd    Operation = "add"
+	OperationRemove Operation = "remove"
+
+	// OperationSync adds the users missing from the channel and optionally removes the members
+	// that are not in the list
+	OperationSync Operation = "sync"
+
+	// OperationUndo removes the channel and team memberships created by a finished add job
+	OperationUndo Operation = "undo"
+)
+
+type UserOutcome string
+
+const (
+	OutcomeAdded                 UserOutcome = "added"
+	OutcomeAlreadyMember         UserOutcome = "already_member"
+	OutcomeNotAddedGuest         UserOutcome = "not_added_guest"
+	OutcomeNotAddedNonTeamMember UserOutcome = "not_added_non_team_member"
+	OutcomeNotAddedDeactivated   UserOutcome = "not_added_deactivated"
+	OutcomeNotAddedBot           UserOutcome = "not_added_bot"
+	OutcomeNotAddedRemote        UserOutcome = "not_added_remote"
+	OutcomeEmailNotFound         UserOutcome = "email_not_found"
+	OutcomeRemoved               UserOutcome = "removed"
+	OutcomeNotMember             UserOutcome = "not_member"
+	OutcomeError                 UserOutcome = "error"
+
+	// OutcomeNotAddedByJob on undo operations, the job being undone didn't add the user to the channel
+	OutcomeNotAddedByJob UserOutcome = "not_added_by_job"
+
+	// OutcomeUnknown the result of the user was lost when resuming an interrupted job
+	OutcomeUnknown UserOutcome = "unknown"
+)
+
+// UserResult is the outcome of processing a single user of the input
+type UserResult struct {
+	// Input the user identifier as provided in the input
+	Input string `json:"input"`
+
+	// UserID the resolved user ID, empty if the user couldn't be resolved
+	UserID string `json:"user_id,omitempty"`
+
+	Outcome UserOutcome `json:"outcome"`
+
+	// AddedToTeam whether the user was added to the team of the channel
+	AddedToTeam bool `json:"added_to_team,omitempty"`
+
+	// RemovedFromTeam whether the user was removed from the team of the channel by an undo
+	RemovedFromTeam bool `json:"removed_from_team,omitempty"`
+
+	// ChannelID the channel the user was added to or removed from
+	ChannelID string `json:"channel_id,omitempty"`
+
+	// Error the reason of the failure when Outcome is OutcomeError
+	Error string `json:"error,omitempty"`
+
+	// interrupted the user was not processed since the job stopped while waiting for the rate limiter
+	interrupted bool
+}
+
+func newErrorUserResult(userID string, err error) UserResult {
+	return UserResult{
+		UserID:  userID,
+		Outcome: OutcomeError,
+		Error:   err.Error(),
+	}
+}
+
+// newInterruptedUserResult the result of a user left unprocessed by a stopped job, processed again if
+// the job is resumed
+func newInterruptedUserResult(userID string) UserResult {
+	return UserResult{UserID: userID, interrupted: true}
+}
+
+// DryRunResult is the predicted outcome of a bulk operation
+type DryRunResult struct {
+	Result bulkChannelAddResult `json:"result"`
+	Users  []UserResult         `json:"users"`
+
+	// ChannelResults the predicted counters of each channel when targeting multiple channels
+	ChannelResults map[string]bulkChannelAddResult `json:"channel_results,omitempty"`
+
+	// SyncDiff the difference between the channel members and the list on sync operations
+	SyncDiff *SyncDiff `json:"sync_diff,omitempty"`
+}
+
+// SyncDiff is the difference between the channel members and the users of a sync operation,
+// computed before processing any user
+type SyncDiff struct {
+	// ToAdd the users of the list that are not channel members
+	ToAdd int `json:"to_add"`
+
+	// ToRemove the channel members that are not in the list and will be removed
+	ToRemove int `json:"to_remove"`
+
+	// Unchanged the users of the list that are already channel members
+	Unchanged int `json:"unchanged"`
+
+	// Kept the channel members that are not in the list but are not removed, either because
+	// removing extra members was not requested or because they are protected
+	Kept int `json:"kept"`
+}
+
+func (d SyncDiff) String() string {
+	return fmt.Sprintf("%d users to add, %d members to remove, %d unchanged and %d members not in the list kept", d.ToAdd, d.ToRemove, d.Unchanged, d.Kept)
 }
 
 type bulkChannelAddResult struct {
-	addedUsers  int
-	addedToTeam int
-	errorUsers  int
+	AddedUsers    int `json:"added_users"`
+	AddedToTeam   int `json:"added_to_team"`
+	AlreadyMember int `json:"already_member"`
+	ErrorUsers    int `json:"error_users"`
+
+	NotAddedGuest         int `json:"not_added_guest"`
+	NotAddedNonTeamMember int `json:"not_added_non_team_member"`
+	NotAddedDeactivated   int `json:"not_added_deactivated"`
+	NotAddedBot           int `json:"not_added_bot"`
+	NotAddedRemote        int `json:"not_added_remote"`
+	EmailNotFound         int `json:"email_not_found"`
+
+	RemovedUsers    int `json:"removed_users"`
+	NotMember       int `json:"not_member"`
+	RemovedFromTeam int `json:"removed_from_team"`
+}
+
+func (bir *bulkChannelAddResult) add(r UserResult) {
+	switch r.Outcome {
+	case OutcomeAdded:
+		bir.AddedUsers++
+	case OutcomeAlreadyMember:
+		bir.AlreadyMember++
+	case OutcomeNotAddedGuest:
+		bir.NotAddedGuest++
+	case OutcomeNotAddedNonTeamMember:
+		bir.NotAddedNonTeamMember++
+	case OutcomeNotAddedDeactivated:
+		bir.NotAddedDeactivated++
+	case OutcomeNotAddedBot:
+		bir.NotAddedBot++
+	case OutcomeNotAddedRemote:
+		bir.NotAddedRemote++
+	case OutcomeEmailNotFound:
+		bir.EmailNotFound++
+	case OutcomeRemoved:
+		bir.RemovedUsers++
+	case OutcomeNotMember:
+		bir.NotMember++
+	case OutcomeError:
+		bir.ErrorUsers++
+	}
+
+	if r.AddedToTeam {
+		bir.AddedToTeam++
+	}
 
-	notAddedGuest         int
-	notAddedNonTeamMember int
+	if r.RemovedFromTeam {
+		bir.RemovedFromTeam++
+	}
 }
 
 func (bir *bulkChannelAddResult) NotAddedCount() int {
-	return bir.notAddedGuest + bir.notAddedNonTeamMember
+	return bir.NotAddedGuest + bir.NotAddedNonTeamMember + bir.NotAddedDeactivated + bir.NotAddedBot + bir.NotAddedRemote + bir.EmailNotFound
 }
 
 func (bir bulkChannelAddResult) String() string {
-	return fmt.Sprintf("%d users were added. %d had errors (check logs) and %d were not added, %d were added to the team.", bir.addedUsers, bir.errorUsers, bir.NotAddedCount(), bir.addedToTeam)
+	return fmt.Sprintf("%d users were added. %d had errors (check the report) and %d were not added, %d were added to the team.", bir.AddedUsers, bir.ErrorUsers, bir.NotAddedCount(), bir.AddedToTeam)
 }
 
 func (bir bulkChannelAddResult) PrettyString() string {
 	prettyString := "Results:\n"
 
-	prettyString += fmt.Sprintf("- **Total users to add**: %d\n", bir.addedUsers)
+	prettyString += fmt.Sprintf("- **Total users to add**: %d\n", bir.AddedUsers)
 
-	if bir.errorUsers > 0 {
-		prettyString += fmt.Sprintf("- **Errors**: %d (check logs)\n", bir.errorUsers)
+	if bir.ErrorUsers > 0 {
+		prettyString += fmt.Sprintf("- **Errors**: %d (check the attached report for details)\n", bir.ErrorUsers)
 	}
 
-	if bir.NotAddedCount() > 0 {
-		prettyString += fmt.Sprintf("- **Not added**: %d\n", bir.NotAddedCount())
+	if bir.AlreadyMember > 0 {
+		prettyString += fmt.Sprintf("- **Already members**: %d\n", bir.AlreadyMember)
+	}
 
-		if bir.notAddedGuest > 0 {
-			prettyString += fmt.Sprintf("  - **Due to being a guest**: %d\n", bir.notAddedGuest)
-		}
+	prettyString += bir.prettyNotAddedString()
 
-		if bir.notAddedNonTeamMember > 0 {
-			prettyString += fmt.Sprintf("  - **Due to not being a team member**: %d\n", bir.notAddedNonTeamMember)
-		}
+	if bir.AddedToTeam > 0 {
+		prettyString += fmt.Sprintf("- **Added to team**: %d\n", bir.AddedToTeam)
+	}
+
+	return prettyString
+}
+
+// prettyNotAddedString formats the users that were not added, by reason
+func (bir bulkChannelAddResult) prettyNotAddedString() string {
+	if bir.NotAddedCount() == 0 {
+		return ""
+	}
+
+	prettyString := fmt.Sprintf("- **Not added**: %d\n", bir.NotAddedCount())
+
+	if bir.NotAddedGuest > 0 {
+		prettyString += fmt.Sprintf("  - **Due to being a guest**: %d\n", bir.NotAddedGuest)
+	}
+
+	if bir.NotAddedNonTeamMember > 0 {
+		prettyString += fmt.Sprintf("  - **Due to not being a team member**: %d\n", bir.NotAddedNonTeamMember)
+	}
+
+	if bir.NotAddedDeactivated > 0 {
+		prettyString += fmt.Sprintf("  - **Due to being deactivated**: %d\n", bir.NotAddedDeactivated)
+	}
+
+	if bir.NotAddedBot > 0 {
+		prettyString += fmt.Sprintf("  - **Due to being a bot**: %d\n", bir.NotAddedBot)
+	}
+
+	if bir.NotAddedRemote > 0 {
+		prettyString += fmt.Sprintf("  - **Due to being a remote user**: %d\n", bir.NotAddedRemote)
+	}
+
+	if bir.EmailNotFound > 0 {
+		prettyString += fmt.Sprintf("  - **Due to email not found**: %d\n", bir.EmailNotFound)
+	}
+
+	return prettyString
+}
+
+// PrettySyncString formats the result of a sync, which both adds and removes users
+func (bir bulkChannelAddResult) PrettySyncString() string {
+	prettyString := "Results:\n"
+
+	prettyString += fmt.Sprintf("- **Total users added**: %d\n", bir.AddedUsers)
+	prettyString += fmt.Sprintf("- **Total users removed**: %d\n", bir.RemovedUsers)
+
+	if bir.ErrorUsers > 0 {
+		prettyString += fmt.Sprintf("- **Errors**: %d (check the attached report for details)\n", bir.ErrorUsers)
+	}
+
+	if bir.AlreadyMember > 0 {
+		prettyString += fmt.Sprintf("- **Already members**: %d\n", bir.AlreadyMember)
+	}
+
+	prettyString += bir.prettyNotAddedString()
+
+	if bir.AddedToTeam > 0 {
+		prettyString += fmt.Sprintf("- **Added to team**: %d\n", bir.AddedToTeam)
+	}
+
+	return prettyString
+}
+
+// PrettyUndoString formats the result of undoing a bulk add
+func (bir bulkChannelAddResult) PrettyUndoString() string {
+	prettyString := "Results:\n"
+
+	prettyString += fmt.Sprintf("- **Total users removed**: %d\n", bir.RemovedUsers)
+
+	if bir.ErrorUsers > 0 {
+		prettyString += fmt.Sprintf("- **Errors**: %d (check the attached report for details)\n", bir.ErrorUsers)
+	}
+
+	if bir.NotMember > 0 {
+		prettyString += fmt.Sprintf("- **Not removed since they already left the channel**: %d\n", bir.NotMember)
+	}
+
+	if bir.RemovedFromTeam > 0 {
+		prettyString += fmt.Sprintf("- **Removed from team**: %d\n", bir.RemovedFromTeam)
+	}
+
+	return prettyString
+}
+
+// PrettyRemoveString formats the result of a bulk remove
+func (bir bulkChannelAddResult) PrettyRemoveString() string {
+	prettyString := "Results:\n"
+
+	prettyString += fmt.Sprintf("- **Total users removed**: %d\n", bir.RemovedUsers)
+
+	if bir.ErrorUsers > 0 {
+		prettyString += fmt.Sprintf("- **Errors**: %d (check the attached report for details)\n", bir.ErrorUsers)
 	}
 
-	if bir.addedToTeam > 0 {
-		prettyString += fmt.Sprintf("- **Added to team**: %d\n", bir.addedToTeam)
+	if bir.NotMember+bir.EmailNotFound > 0 {
+		prettyString += fmt.Sprintf("- **Not removed**: %d\n", bir.NotMember+bir.EmailNotFound)
+
+		if bir.NotMember > 0 {
+			prettyString += fmt.Sprintf("  - **Due to not being a channel member**: %d\n", bir.NotMember)
+		}
+
+		if bir.EmailNotFound > 0 {
+			prettyString += fmt.Sprintf("  - **Due to email not found**: %d\n", bir.EmailNotFound)
+		}
 	}
 
 	return prettyString
 }
 
+// Config is a bulk operation to run. It's stored as JSON in scheduled operations.
 type Config struct {
-	// ChannelID the channel to add users to
-	ChannelID string
+	// Operation the operation to apply to the users, OperationAdd if empty
+	Operation Operation `json:"operation"`
+
+	// ChannelID the channel to add users to or remove users from. On jobs targeting multiple
+	// channels, the first channel of ChannelIDs where the job progress is posted.
+	ChannelID string `json:"channel_id"`
 	channel   *model.Channel
 
-	// UserID stores the user ID that is inviting all users to the channel
-	UserID string
+	// ChannelIDs the channels targeted by the job, only ChannelID if empty
+	ChannelIDs []string `json:"channel_ids,omitempty"`
+	channels   []*model.Channel
+
+	// UserID stores the user ID that is triggering the operation
+	UserID string `json:"user_id"`
+
+	// Users are all the Users the operation is applied to
+	Users []AddUser `json:"users,omitempty"`
 
-	// Users are all the Users that require inviting to a channel
-	Users []AddUser
+	// SourceChannelID the channel whose members are added to Users
+	SourceChannelID string `json:"source_channel_id,omitempty"`
+
+	// SourceGroupID the user group whose members are added to Users
+	SourceGroupID string `json:"source_group_id,omitempty"`
+
+	// SourceTeamID the team whose members are added to Users
+	SourceTeamID string `json:"source_team_id,omitempty"`
 
 	// AddToTeam add users to the team if they do not belong to it
-	AddToTeam bool
+	AddToTeam bool `json:"add_to_team"`
+
+	// GuestPolicy how guests are treated when adding users, the policy of the plugin settings if empty
+	GuestPolicy GuestPolicy `json:"guest_policy,omitempty"`
+
+	// guestPolicyFromSettings the request didn't set GuestPolicy, so it can be lowered to the
+	// permissions of the user instead of failing
+	guestPolicyFromSettings bool
+
+	// RemoveExtras on sync operations, remove the channel members that are not in Users
+	RemoveExtras bool `json:"remove_extras,omitempty"`
+
+	// UndoOfJobID on undo operations, the job whose memberships are removed
+	UndoOfJobID string `json:"undo_of_job_id,omitempty"`
+
+	// undo the memberships created by the job being undone
+	undo undoRecords
+
+	// syncDiff the difference between the channel members and Users computed for sync operations
+	syncDiff *SyncDiff
+
+	// DryRun check the outcome for every user without adding them to the channel or the team
+	DryRun bool `json:"-"`
+
+	// teamUsers the prefetched users of the channel team by ID, nil if they were not prefetched
+	teamUsers map[string]*model.User
+}
+
+// isRemove returns true if the users are removed from the channel instead of added
+func (c *Config) isRemove() bool {
+	return c.Operation == OperationRemove
+}
+
+// isSync returns true if the channel members are synced with the users
+func (c *Config) isSync() bool {
+	return c.Operation == OperationSync
+}
+
+// isUndo returns true if the memberships created by another job are removed
+func (c *Config) isUndo() bool {
+	return c.Operation == OperationUndo
+}
+
+// operationName the name of the operation used in messages
+func (c *Config) operationName() string {
+	return operationName(c.Operation)
+}
+
+// channelAction describes the operation applied to a channel in messages, followed by the channel
+func (c *Config) channelAction() string {
+	switch c.Operation {
+	case OperationRemove:
+		return "remove users from"
+	case OperationSync:
+		return "sync the members of"
+	case OperationUndo:
+		return "undo the additions to"
+	}
+	return "add users to"
+}
+
+// operationName the name of an operation used in messages and file names, "add" if empty
+func operationName(operation Operation) string {
+	switch operation {
+	case OperationRemove, OperationSync, OperationUndo:
+		return string(operation)
+	}
+	return "add"
+}
+
+// normalizeChannels sets the targeted channels of the config, removing duplicated channels
+func (c *Config) normalizeChannels() {
+	if len(c.ChannelIDs) == 0 {
+		c.ChannelIDs = []string{c.ChannelID}
+		return
+	}
+
+	channelIDs := []string{}
+	seen := map[string]bool{}
+	for _, channelID := range c.ChannelIDs {
+		if !seen[channelID] {
+			seen[channelID] = true
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	c.ChannelIDs = channelIDs
+	c.ChannelID = channelIDs[0]
+}
+
+// isMultiChannel returns true if the job targets more than one channel
+func (c *Config) isMultiChannel() bool {
+	return len(c.ChannelIDs) > 1
+}
+
+// channelConfig returns a copy of the config targeting only the channel at the index of ChannelIDs
+func (c *Config) channelConfig(index int) *Config {
+	channelConfig := *c
+	channelConfig.ChannelID = c.ChannelIDs[index]
+	channelConfig.ChannelIDs = []string{c.ChannelIDs[index]}
+	channelConfig.channel = nil
+	channelConfig.channels = nil
+	if index < len(c.channels) {
+		channelConfig.channel = c.channels[index]
+		channelConfig.channels = []*model.Channel{c.channels[index]}
+	}
+	channelConfig.teamUsers = nil
+
+	return &channelConfig
 }
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// progressTracker keeps the progress post of a running job up to date. The post starts with the
+// header of the job and is finalized with its result.
+type progressTracker struct {
+	engine *Engine
+	config *Config
+	job    *Job
+
+	// post the progress post, nil if it couldn't be created
+	post *model.Post
+
+	// header the first line of the post, describing the job
+	header string
+
+	// interval the minimum time between updates, 0 to only update the post when the job finishes
+	interval time.Duration
+
+	// startAt and startProcessed the time and processed users when this run of the job started,
+	// used to estimate the remaining time of resumed jobs too
+	startAt        time.Time
+	startProcessed int
+
+	lastUpdateAt time.Time
+}
+
+// newProgressTracker posts the header of the job in its first channel, or updates the existing post
+// when the job is resumed
+func (e *Engine) newProgressTracker(config *Config, job *Job, header string) *progressTracker {
+	settings, _ := e.getSettings()
+	tracker := &progressTracker{
+		engine:         e,
+		config:         config,
+		job:            job,
+		header:         header,
+		interval:       time.Duration(settings.ProgressUpdateIntervalSeconds) * time.Second,
+		startAt:        time.Now(),
+		startProcessed: job.ProcessedUsers,
+		lastUpdateAt:   time.Now(),
+	}
+
+	if job.ProgressPostID != "" {
+		// Only the message changes, the rest of the post (props, attachments...) is kept as is
+		post, appErr := e.API.GetPost(job.ProgressPostID)
+		if appErr == nil {
+			post = post.Clone()
+			post.Message = tracker.message()
+			post, appErr = e.API.UpdatePost(post)
+		}
+		if appErr == nil {
+			tracker.post = post
+			return tracker
+		}
+		e.API.LogWarn("error updating progress post of resumed job, creating a new one", "job_id", job.ID, "post_id", job.ProgressPostID, "err", appErr.Error())
+	}
+
+	post, appErr := e.API.CreatePost(&model.Post{
+		ChannelId: config.ChannelID,
+		UserId:    e.botUserID,
+		Message:   header,
+	})
+	if appErr != nil {
+		e.API.LogError("error creating initial post in channel", "channel_id", config.ChannelID, "err", appErr.Error())
+		return tracker
+	}
+
+	tracker.post = post
+	job.ProgressPostID = post.Id
+	return tracker
+}
+
+// update refreshes the progress post if the update interval elapsed since the last update
+func (t *progressTracker) update() {
+	if t.post == nil || t.interval <= 0 || time.Since(t.lastUpdateAt) < t.interval {
+		return
+	}
+
+	t.setMessage(t.message())
+	t.lastUpdateAt = time.Now()
+}
+
+// finish replaces the progress of the post with the final message and the result of the job. Returns
+// false if the post couldn't be updated.
+func (t *progressTracker) finish(message string) bool {
+	if t.post == nil {
+		return false
+	}
+
+	return t.setMessage(fmt.Sprintf("%s\n\n%s\n\n%s", t.header, message, resultMessage(t.config, t.job)))
+}
+
+func (t *progressTracker) setMessage(message string) bool {
+	post := t.post.Clone()
+	post.Message = message
+	updated, appErr := t.engine.API.UpdatePost(post)
+	if appErr != nil {
+		t.engine.API.LogError("error updating progress post", "job_id", t.job.ID, "post_id", t.post.Id, "err", appErr.Error())
+		return false
+	}
+
+	if updated != nil {
+		t.post = updated
+	}
+	return true
+}
+
+// message formats the progress of the job: processed users, outcome counters and estimated time left
+func (t *progressTracker) message() string {
+	job := t.job
+	percent := 0
+	if job.TotalUsers > 0 {
+		percent = job.ProcessedUsers * 100 / job.TotalUsers
+	}
+
+	var counters string
+	switch {
+	case t.config.isRemove(), t.config.isUndo():
+		counters = fmt.Sprintf("%d removed", job.Result.RemovedUsers)
+	case t.config.isSync():
+		counters = fmt.Sprintf("%d added, %d removed", job.Result.AddedUsers, job.Result.RemovedUsers)
+	default:
+		counters = fmt.Sprintf("%d added", job.Result.AddedUsers)
+	}
+
+	return fmt.Sprintf(
+		"%s\n\n**Progress**: %d of %d users processed (%d%%), %s, %d errors. Estimated time remaining: %s.",
+		t.header, job.ProcessedUsers, job.TotalUsers, percent, counters, job.Result.ErrorUsers, t.eta(),
+	)
+}
+
+// eta estimates the time left from the users processed per second since the job started running
+func (t *progressTracker) eta() string {
+	processed := t.job.ProcessedUsers - t.startProcessed
+	if processed <= 0 {
+		return "calculating"
+	}
+
+	remaining := t.job.TotalUsers - t.job.ProcessedUsers
+	eta := time.Since(t.startAt) / time.Duration(processed) * time.Duration(remaining)
+	return eta.Round(time.Second).String()
+}
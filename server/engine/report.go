@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Report is the per-user outcome of a job, in the same order as the input
+type Report []UserResult
+
+var reportCSVHeader = []string{"input", "user_id", "channel_id", "outcome", "added_to_team", "removed_from_team", "error"}
+
+func (r Report) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(reportCSVHeader); err != nil {
+		return nil, fmt.Errorf("error writing report header: %w", err)
+	}
+
+	for _, userResult := range r {
+		if err := w.Write([]string{
+			userResult.Input,
+			userResult.UserID,
+			userResult.ChannelID,
+			string(userResult.Outcome),
+			strconv.FormatBool(userResult.AddedToTeam),
+			strconv.FormatBool(userResult.RemovedFromTeam),
+			userResult.Error,
+		}); err != nil {
+			return nil, fmt.Errorf("error writing report row: %w", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("error writing report: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (r Report) JSON() ([]byte, error) {
+	// Always return a list, even if there are no results
+	if r == nil {
+		r = Report{}
+	}
+
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// ReportFilename returns the name of the report file of a job for the provided extension, named after
+// the operation of the job
+func ReportFilename(job *Job, extension string) string {
+	return fmt.Sprintf("bulk-%s-report-%s.%s", operationName(job.Operation), job.ID, extension)
+}
+
+// uploadReport uploads the report of the job as CSV and JSON files to the channel, returning the
+// uploaded file IDs.
+func (e *Engine) uploadReport(job *Job, report Report) []string {
+	fileIDs := []string{}
+
+	csvData, err := report.CSV()
+	if err != nil {
+		e.API.LogError("error generating csv report", "job_id", job.ID, "err", err.Error())
+	} else if fileID, ok := e.uploadReportFile(job, csvData, ReportFilename(job, "csv")); ok {
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	jsonData, err := report.JSON()
+	if err != nil {
+		e.API.LogError("error generating json report", "job_id", job.ID, "err", err.Error())
+	} else if fileID, ok := e.uploadReportFile(job, jsonData, ReportFilename(job, "json")); ok {
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	return fileIDs
+}
+
+func (e *Engine) uploadReportFile(job *Job, data []byte, filename string) (string, bool) {
+	fileInfo, appErr := e.API.UploadFile(data, job.ChannelID, filename)
+	if appErr != nil {
+		e.API.LogError("error uploading report file", "job_id", job.ID, "channel_id", job.ChannelID, "filename", filename, "err", appErr.Error())
+		return "", false
+	}
+
+	return fileInfo.Id, true
+}
+
+// saveReport stores the results of the report starting from the user at position from. Results are
+// stored in chunks of jobSaveInterval users so progress updates only write the latest users. The
+// chunks expire along with the finished job.
+func (e *Engine) saveReport(jobID string, report Report, from int) {
+	ttl := e.finishedJobTTL()
+	for chunk := from / jobSaveInterval; chunk*jobSaveInterval < len(report); chunk++ {
+		end := (chunk + 1) * jobSaveInterval
+		if end > len(report) {
+			end = len(report)
+		}
+
+		if err := e.jobStore.SaveJobReportChunk(jobID, chunk, report[chunk*jobSaveInterval:end], ttl); err != nil {
+			e.API.LogError("error storing job report", "job_id", jobID, "chunk", chunk, "err", err.Error())
+		}
+	}
+}
+
+// loadReport loads the report of a job being resumed, adjusting it to the processed users
+func (e *Engine) loadReport(job *Job, config *Config) Report {
+	report, err := e.jobStore.GetJobReport(job.ID)
+	if err != nil {
+		e.API.LogError("error loading job report", "job_id", job.ID, "err", err.Error())
+	}
+
+	// Results stored after the last progress update belong to users that will be processed again
+	if len(report) > job.ProcessedUsers {
+		report = report[:job.ProcessedUsers]
+	}
+
+	for i := len(report); i < job.ProcessedUsers; i++ {
+		userResult := UserResult{
+			Input:   config.Users[i%len(config.Users)].Identifier(),
+			Outcome: OutcomeUnknown,
+		}
+		if config.isMultiChannel() {
+			userResult.ChannelID = config.ChannelIDs[i/len(config.Users)]
+		}
+		report = append(report, userResult)
+	}
+
+	return report
+}
+
+// GetJobReport returns the per-user outcomes of a job
+func (e *Engine) GetJobReport(jobID string) (Report, error) {
+	return e.jobStore.GetJobReport(jobID)
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// teamUsersPerPage the page size used to prefetch the users of the team
+	teamUsersPerPage = 200
+
+	// maxTeamUsersPages the maximum number of pages prefetched, larger teams are never loaded in
+	// memory and the team membership of each user is checked instead
+	maxTeamUsersPages = 10
+)
+
+// resolvedUser the user of an input entry, or its result if the user couldn't be resolved
+type resolvedUser struct {
+	user   *model.User
+	result *UserResult
+}
+
+// prefetchTeamUsers loads the users of small teams when paging through them takes fewer requests than
+// checking the team membership of the users to process one by one. Removals and undos don't check
+// the team membership.
+func (e *Engine) prefetchTeamUsers(config *Config, usersToProcess int) {
+	if usersToProcess == 0 || config.isRemove() || config.isUndo() {
+		return
+	}
+
+	stats, appErr := e.API.GetTeamStats(config.channel.TeamId)
+	if appErr != nil {
+		e.API.LogWarn("error getting team stats, checking team memberships one by one", "team_id", config.channel.TeamId, "err", appErr.Error())
+		return
+	}
+
+	pages := int((stats.TotalMemberCount + teamUsersPerPage - 1) / teamUsersPerPage)
+	if pages > usersToProcess || pages > maxTeamUsersPages {
+		return
+	}
+
+	teamUsers := make(map[string]*model.User, stats.TotalMemberCount)
+	for page := 0; ; page++ {
+		users, appErr := e.API.GetUsersInTeam(config.channel.TeamId, page, teamUsersPerPage)
+		if appErr != nil {
+			e.API.LogWarn("error getting team users, checking team memberships one by one", "team_id", config.channel.TeamId, "page", page, "err", appErr.Error())
+			return
+		}
+
+		for _, user := range users {
+			teamUsers[user.Id] = user
+		}
+
+		if len(users) < teamUsersPerPage {
+			break
+		}
+	}
+
+	config.teamUsers = teamUsers
+}
+
+// resolveUsers looks up the users of the input entries. Usernames are resolved with a single request,
+// user IDs and emails need a request per user since the plugin API can't get them in batch.
+func (e *Engine) resolveUsers(config *Config, users []AddUser) []resolvedUser {
+	resolved := make([]resolvedUser, len(users))
+
+	usersByUsername, appErr := e.getUsersByUsernames(users)
+	if appErr != nil {
+		e.API.LogError("error getting users by username", "user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+	}
+
+	for i, u := range users {
+		switch {
+		case u.UserID != "":
+			resolved[i] = e.resolveUserByID(config, u.UserID)
+		case u.Username != "":
+			if appErr != nil {
+				resolved[i] = newUnresolvedUser(newErrorUserResult("", fmt.Errorf("error getting user by username: %w", appErr)))
+				continue
+			}
+
+			user, ok := usersByUsername[normalizeUsername(u.Username)]
+			if !ok {
+				e.API.LogInfo("not inviting user since the username was not found", "username", u.Username, "trigger_user_id", config.UserID, "channel_id", config.ChannelID)
+				resolved[i] = newUnresolvedUser(newErrorUserResult("", fmt.Errorf("error getting user by username: user not found")))
+				continue
+			}
+			resolved[i] = resolvedUser{user: user}
+		case u.Email != "":
+			resolved[i] = e.resolveUserByEmail(config, u.Email)
+		default:
+			resolved[i] = newUnresolvedUser(UserResult{Outcome: OutcomeError, Error: "missing user_id, username or email"})
+		}
+	}
+
+	return resolved
+}
+
+// getUsersByUsernames gets the users of the entries identified by username, by their normalized username
+func (e *Engine) getUsersByUsernames(users []AddUser) (map[string]*model.User, *model.AppError) {
+	usernames := []string{}
+	for _, u := range users {
+		if u.UserID == "" && u.Username != "" {
+			usernames = append(usernames, normalizeUsername(u.Username))
+		}
+	}
+
+	usersByUsername := map[string]*model.User{}
+	if len(usernames) == 0 {
+		return usersByUsername, nil
+	}
+
+	found, appErr := e.API.GetUsersByUsernames(usernames)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	for _, user := range found {
+		usersByUsername[user.Username] = user
+	}
+
+	return usersByUsername, nil
+}
+
+func (e *Engine) resolveUserByID(config *Config, userID string) resolvedUser {
+	if user, ok := config.teamUsers[userID]; ok {
+		return resolvedUser{user: user}
+	}
+
+	user, appErr := e.API.GetUser(userID)
+	if appErr != nil {
+		e.API.LogError("error getting user information", "add_user_id", userID, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+		return newUnresolvedUser(newErrorUserResult(userID, fmt.Errorf("error getting user: %w", appErr)))
+	}
+
+	return resolvedUser{user: user}
+}
+
+func (e *Engine) resolveUserByEmail(config *Config, email string) resolvedUser {
+	// Emails are stored in lowercase
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	user, appErr := e.API.GetUserByEmail(email)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			e.API.LogInfo("not inviting user since the email was not found", "email", email, "trigger_user_id", config.UserID, "channel_id", config.ChannelID)
+			return newUnresolvedUser(UserResult{Outcome: OutcomeEmailNotFound})
+		}
+		e.API.LogError("error getting user by email", "email", email, "user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+		return newUnresolvedUser(newErrorUserResult("", fmt.Errorf("error getting user by email: %w", appErr)))
+	}
+
+	return resolvedUser{user: user}
+}
+
+// getChannelMembers returns the resolved users that are already members of the channel, or nil if
+// the memberships couldn't be checked.
+func (e *Engine) getChannelMembers(config *Config, resolved []resolvedUser) map[string]bool {
+	userIDs := []string{}
+	for _, r := range resolved {
+		if r.user != nil {
+			userIDs = append(userIDs, r.user.Id)
+		}
+	}
+
+	channelMembers := map[string]bool{}
+	if len(userIDs) == 0 {
+		return channelMembers
+	}
+
+	members, appErr := e.API.GetChannelMembersByIds(config.ChannelID, userIDs)
+	if appErr != nil {
+		e.API.LogError("error getting channel members", "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+		return nil
+	}
+
+	for _, member := range members {
+		channelMembers[member.UserId] = true
+	}
+
+	return channelMembers
+}
+
+// isTeamMember checks the team membership of the user in the prefetched team users, falling back to
+// getting the membership of the user.
+func (e *Engine) isTeamMember(config *Config, userID string) (bool, error) {
+	if config.teamUsers != nil {
+		_, ok := config.teamUsers[userID]
+		return ok, nil
+	}
+
+	teamMember, appErr := e.API.GetTeamMember(config.channel.TeamId, userID)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, appErr
+	}
+
+	return teamMember.DeleteAt == 0, nil
+}
+
+func newUnresolvedUser(result UserResult) resolvedUser {
+	return resolvedUser{result: &result}
+}
+
+// normalizeUsername usernames are stored in lowercase
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
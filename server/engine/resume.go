@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
+)
+
+// stopTimeout the maximum time to wait for running jobs to store their progress on shutdown
+const stopTimeout = 10 * time.Second
+
+// errJobInterrupted the cancel cause of the jobs stopped by a plugin shutdown or by losing a channel lock
+var errJobInterrupted = errors.New("job interrupted")
+
+// Stop interrupts the jobs running in this node, waiting for them to store their progress so they
+// can be resumed later.
+func (e *Engine) Stop() {
+	e.runningJobsLock.Lock()
+	for _, cancel := range e.runningJobs {
+		cancel(errJobInterrupted)
+	}
+	e.runningJobsLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.runningJobsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		e.API.LogWarn("timed out waiting for running jobs to stop")
+	}
+}
+
+// isResumable returns true if the job was interrupted by a plugin shutdown or a node crash
+func (e *Engine) isResumable(job *Job) bool {
+	switch job.State {
+	case JobStateInterrupted:
+		return true
+	case JobStateQueued, JobStateRunning:
+		return e.isAbandoned(job)
+	}
+
+	return false
+}
+
+// isAbandoned returns true if a queued or running job stopped renewing its channel locks, like the
+// jobs of a crashed node. Jobs running in a live node renew them on every heartbeat, no matter how
+// long their batches take.
+func (e *Engine) isAbandoned(job *Job) bool {
+	for _, channelID := range job.ChannelIDs {
+		lock, err := e.lockStore.GetLock(channelID)
+		if errors.Is(err, kvstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			e.API.LogError("error getting channel lock", "job_id", job.ID, "channel_id", channelID, "err", err.Error())
+			return false
+		}
+
+		if lock.Owner == job.ID && !lock.IsStale() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ResumeJobs resumes the jobs interrupted by a plugin shutdown or a node crash from their last
+// processed user.
+func (e *Engine) ResumeJobs() error {
+	jobs, err := e.jobStore.ListActiveJobs()
+	if err != nil {
+		return fmt.Errorf("error listing active jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		if !e.isResumable(job) {
+			continue
+		}
+
+		if err := e.resumeJob(job); err != nil {
+			e.API.LogError("error resuming job", "job_id", job.ID, "channel_id", job.ChannelID, "err", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) resumeJob(job *Job) error {
+	// Other nodes may be trying to resume the same job
+	claimed, err := e.jobStore.ClaimJob(job)
+	if err != nil {
+		return fmt.Errorf("error claiming job: %w", err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	users, err := e.jobStore.GetJobInput(job.ID)
+	if err != nil {
+		e.failJob(job, fmt.Errorf("error getting job input: %w", err))
+		return fmt.Errorf("error getting job input: %w", err)
+	}
+
+	config := &Config{
+		Operation:    job.Operation,
+		ChannelID:    job.ChannelID,
+		ChannelIDs:   job.ChannelIDs,
+		UserID:       job.UserID,
+		Users:        users,
+		AddToTeam:    job.AddToTeam,
+		GuestPolicy:  job.GuestPolicy,
+		RemoveExtras: job.RemoveExtras,
+		UndoOfJobID:  job.UndoOfJobID,
+		syncDiff:     job.SyncDiff,
+	}
+
+	// Permissions may have changed while the job was interrupted
+	if perr := e.validateConfig(config); perr != nil {
+		e.failJob(job, perr)
+		return fmt.Errorf("error validating job: %w", perr)
+	}
+
+	// A job abandoned by a crashed node still holds the channel locks
+	if job.State != JobStateInterrupted {
+		e.unlockChannels(config.ChannelIDs, job.ID)
+	}
+
+	if err := e.lockChannels(config.ChannelIDs, job.ID); err != nil {
+		// The job can be resumed again once the channels are unlocked
+		if releaseErr := e.jobStore.ReleaseJobClaim(job); releaseErr != nil {
+			e.API.LogError("error releasing job claim", "job_id", job.ID, "err", releaseErr.Error())
+		}
+		return fmt.Errorf("error locking channels: %w", err)
+	}
+
+	e.API.LogInfo("resuming bulk job", "job_id", job.ID, "channel_id", job.ChannelID, "processed_users", job.ProcessedUsers)
+
+	job.State = JobStateQueued
+	e.saveJob(job)
+
+	e.run(context.Background(), config, job)
+
+	return nil
+}
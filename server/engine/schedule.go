@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Schedule is a bulk operation started at a future time, once or periodically
+type Schedule struct {
+	ID string `json:"id"`
+
+	// Config the operation to start. Sources are expanded on every run, so recurring operations use
+	// the members the source channel, group or team has at the time.
+	Config Config `json:"config"`
+
+	// RunAt the next time the operation is started, 0 if it won't run again
+	RunAt int64 `json:"run_at"`
+
+	// IntervalHours the hours between the runs of recurring schedules, 0 for a single run
+	IntervalHours int `json:"interval_hours,omitempty"`
+
+	// LastRunAt the last time the operation was started
+	LastRunAt int64 `json:"last_run_at,omitempty"`
+
+	// LastJobID the job started by the last run
+	LastJobID string `json:"last_job_id,omitempty"`
+
+	// LastError the reason the last run couldn't start the job
+	LastError string `json:"last_error,omitempty"`
+
+	CreateAt int64 `json:"create_at"`
+}
+
+// TargetsChannel returns true if the channel is one of the channels targeted by the scheduled operation
+func (s *Schedule) TargetsChannel(channelID string) bool {
+	if s.Config.ChannelID == channelID {
+		return true
+	}
+
+	for _, targetChannelID := range s.Config.ChannelIDs {
+		if targetChannelID == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSchedule validates the scheduled operation with the permissions the user has now and stores
+// it. The permissions are checked again on every run.
+func (e *Engine) CreateSchedule(schedule *Schedule) (*Schedule, *perror.PError) {
+	if schedule.Config.Operation == OperationUndo {
+		return nil, perror.NewPError(fmt.Errorf("undo_not_schedulable"), "Undo operations can't be scheduled.")
+	}
+
+	if schedule.RunAt <= model.GetMillis() {
+		return nil, perror.NewPError(fmt.Errorf("run_at_in_the_past"), "The scheduled time must be in the future.")
+	}
+
+	if schedule.IntervalHours < 0 {
+		return nil, perror.NewPError(fmt.Errorf("invalid interval %d", schedule.IntervalHours), "The interval must be a positive number of hours.")
+	}
+
+	if len(schedule.Config.Users) == 0 && !schedule.Config.hasSources() {
+		return nil, perror.NewPError(fmt.Errorf("missing users"), "User list is empty.")
+	}
+
+	// Runs without a guest policy use the one configured when they start
+	guestPolicy := schedule.Config.GuestPolicy
+	schedule.Config.DryRun = false
+	if perr := e.validateConfig(&schedule.Config); perr != nil {
+		return nil, perr
+	}
+	schedule.Config.GuestPolicy = guestPolicy
+
+	schedule.ID = model.NewId()
+	schedule.CreateAt = model.GetMillis()
+	schedule.LastRunAt = 0
+	schedule.LastJobID = ""
+	schedule.LastError = ""
+
+	if err := e.jobStore.CreateSchedule(schedule); err != nil {
+		return nil, perror.NewInternalServerPError(fmt.Errorf("error storing schedule: %w", err))
+	}
+
+	return schedule, nil
+}
+
+// GetSchedule returns the stored schedule with the provided ID if the user can access it, like
+// GetJob does for jobs
+func (e *Engine) GetSchedule(userID, scheduleID string) (*Schedule, error) {
+	schedule, err := e.jobStore.GetSchedule(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.canUserAccessSchedule(userID, schedule) {
+		return nil, kvstore.ErrNotFound
+	}
+
+	return schedule, nil
+}
+
+// ListSchedules returns the stored schedules, next to run first
+func (e *Engine) ListSchedules() ([]*Schedule, error) {
+	return e.jobStore.ListSchedules()
+}
+
+// DeleteSchedule removes a schedule, the jobs it already started are not affected
+func (e *Engine) DeleteSchedule(scheduleID string) error {
+	return e.jobStore.DeleteSchedule(scheduleID)
+}
+
+// canUserAccessSchedule returns true if the user created the schedule or is a system admin
+func (e *Engine) canUserAccessSchedule(userID string, schedule *Schedule) bool {
+	return schedule.Config.UserID == userID || e.IsSystemAdmin(userID)
+}
+
+// RunSchedules starts the scheduled operations that are due. Called periodically by a cluster job,
+// which runs in a single node at a time.
+func (e *Engine) RunSchedules() {
+	e.runSchedules(model.GetMillis())
+}
+
+func (e *Engine) runSchedules(now int64) {
+	schedules, err := e.jobStore.ListSchedules()
+	if err != nil {
+		e.API.LogError("error listing schedules", "err", err.Error())
+		return
+	}
+
+	for _, schedule := range schedules {
+		if schedule.RunAt == 0 || schedule.RunAt > now {
+			continue
+		}
+
+		// Protects against a run overlapping with a node that took over the cluster job
+		claimed, err := e.jobStore.ClaimScheduleRun(schedule)
+		if err != nil {
+			e.API.LogError("error claiming schedule run", "schedule_id", schedule.ID, "err", err.Error())
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		e.runSchedule(schedule, now)
+	}
+}
+
+// runSchedule starts the job of a due schedule and sets its next run. Single run schedules are
+// deleted once their job starts, or kept with the error otherwise.
+func (e *Engine) runSchedule(schedule *Schedule, now int64) {
+	config := schedule.Config
+	config.Users = append([]AddUser{}, schedule.Config.Users...)
+	config.ChannelIDs = append([]string{}, schedule.Config.ChannelIDs...)
+
+	schedule.LastRunAt = now
+	job, perr := e.StartJob(context.Background(), &config)
+	if perr != nil {
+		e.API.LogWarn("error starting scheduled job", "schedule_id", schedule.ID, "channel_id", schedule.Config.ChannelID, "err", perr.Error())
+		schedule.LastJobID = ""
+		schedule.LastError = perr.Message()
+	} else {
+		schedule.LastJobID = job.ID
+		schedule.LastError = ""
+	}
+
+	if schedule.IntervalHours == 0 {
+		if perr == nil {
+			if err := e.jobStore.DeleteSchedule(schedule.ID); err != nil {
+				e.API.LogError("error deleting schedule", "schedule_id", schedule.ID, "err", err.Error())
+			}
+			return
+		}
+		schedule.RunAt = 0
+	} else {
+		// Runs missed while the plugin was not running are skipped
+		interval := (time.Duration(schedule.IntervalHours) * time.Hour).Milliseconds()
+		for schedule.RunAt <= now {
+			schedule.RunAt += interval
+		}
+	}
+
+	if err := e.jobStore.SaveSchedule(schedule); err != nil {
+		e.API.LogError("error storing schedule", "schedule_id", schedule.ID, "err", err.Error())
+	}
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// sourceMembersPerPage the page size used to get the members of a source channel, group or team
+const sourceMembersPerPage = 200
+
+// hasSources returns true if the users of the config come from channels, groups or teams
+func (c *Config) hasSources() bool {
+	return c.SourceChannelID != "" || c.SourceGroupID != "" || c.SourceTeamID != ""
+}
+
+// loadSourceUsers expands the source channel, group and team of the config into its users, without
+// duplicated users. Sources are combined with the provided users.
+func (e *Engine) loadSourceUsers(config *Config) *perror.PError {
+	if !config.hasSources() {
+		return nil
+	}
+
+	var sourceUserIDs []string
+	if config.SourceChannelID != "" {
+		userIDs, perr := e.getSourceChannelUserIDs(config)
+		if perr != nil {
+			return perr
+		}
+		sourceUserIDs = append(sourceUserIDs, userIDs...)
+	}
+
+	if config.SourceGroupID != "" {
+		userIDs, perr := e.getSourceGroupUserIDs(config)
+		if perr != nil {
+			return perr
+		}
+		sourceUserIDs = append(sourceUserIDs, userIDs...)
+	}
+
+	if config.SourceTeamID != "" {
+		userIDs, perr := e.getSourceTeamUserIDs(config)
+		if perr != nil {
+			return perr
+		}
+		sourceUserIDs = append(sourceUserIDs, userIDs...)
+	}
+
+	seen := map[string]bool{}
+	for _, u := range config.Users {
+		if u.UserID != "" {
+			seen[u.UserID] = true
+		}
+	}
+
+	for _, userID := range sourceUserIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			config.Users = append(config.Users, AddUser{UserID: userID})
+		}
+	}
+
+	if len(config.Users) == 0 {
+		return perror.NewPError(fmt.Errorf("empty sources"), "The source channel, group or team has no members")
+	}
+
+	return nil
+}
+
+func (e *Engine) getSourceChannelUserIDs(config *Config) ([]string, *perror.PError) {
+	for _, channelID := range config.ChannelIDs {
+		if channelID == config.SourceChannelID {
+			return nil, perror.NewPError(fmt.Errorf("source_channel_is_target"), "The source channel can't be one of the target channels")
+		}
+	}
+
+	if !e.API.HasPermissionToChannel(config.UserID, config.SourceChannelID, model.PermissionReadChannel) {
+		return nil, perror.NewPError(fmt.Errorf("insufficient_source_channel_permissions"), "You dont have permission to read the members of the source channel")
+	}
+
+	userIDs := []string{}
+	for page := 0; ; page++ {
+		members, appErr := e.API.GetChannelMembers(config.SourceChannelID, page, sourceMembersPerPage)
+		if appErr != nil {
+			e.API.LogError("error getting source channel members", "source_channel_id", config.SourceChannelID, "page", page, "err", appErr.Error())
+			return nil, perror.NewInternalServerPError(fmt.Errorf("error getting source channel members: %w", appErr))
+		}
+
+		for _, member := range members {
+			userIDs = append(userIDs, member.UserId)
+		}
+
+		if len(members) < sourceMembersPerPage {
+			return userIDs, nil
+		}
+	}
+}
+
+func (e *Engine) getSourceGroupUserIDs(config *Config) ([]string, *perror.PError) {
+	group, appErr := e.API.GetGroup(config.SourceGroupID)
+	if appErr != nil && appErr.StatusCode != http.StatusNotFound {
+		e.API.LogError("error getting source group", "source_group_id", config.SourceGroupID, "err", appErr.Error())
+		return nil, perror.NewInternalServerPError(fmt.Errorf("error getting source group: %w", appErr))
+	}
+	if group == nil || group.DeleteAt != 0 {
+		return nil, perror.NewPError(fmt.Errorf("source group not found"), fmt.Sprintf("User group `%s` not found", config.SourceGroupID))
+	}
+
+	userIDs := []string{}
+	for page := 0; ; page++ {
+		users, appErr := e.API.GetGroupMemberUsers(config.SourceGroupID, page, sourceMembersPerPage)
+		if appErr != nil {
+			e.API.LogError("error getting source group members", "source_group_id", config.SourceGroupID, "page", page, "err", appErr.Error())
+			return nil, perror.NewInternalServerPError(fmt.Errorf("error getting source group members: %w", appErr))
+		}
+
+		for _, user := range users {
+			userIDs = append(userIDs, user.Id)
+		}
+
+		if len(users) < sourceMembersPerPage {
+			return userIDs, nil
+		}
+	}
+}
+
+func (e *Engine) getSourceTeamUserIDs(config *Config) ([]string, *perror.PError) {
+	if !e.API.HasPermissionToTeam(config.UserID, config.SourceTeamID, model.PermissionViewTeam) {
+		return nil, perror.NewPError(fmt.Errorf("insufficient_source_team_permissions"), "You dont have permission to view the members of the source team")
+	}
+
+	userIDs := []string{}
+	for page := 0; ; page++ {
+		members, appErr := e.API.GetTeamMembers(config.SourceTeamID, page, sourceMembersPerPage)
+		if appErr != nil {
+			e.API.LogError("error getting source team members", "source_team_id", config.SourceTeamID, "page", page, "err", appErr.Error())
+			return nil, perror.NewInternalServerPError(fmt.Errorf("error getting source team members: %w", appErr))
+		}
+
+		for _, member := range members {
+			// Skip the users that left the team
+			if member.DeleteAt == 0 {
+				userIDs = append(userIDs, member.UserId)
+			}
+		}
+
+		if len(members) < sourceMembersPerPage {
+			return userIDs, nil
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// channelMembersPerPage the page size used to get the current members and users of a synced channel
+const channelMembersPerPage = 200
+
+// loadSyncDiff compares the users of a sync operation with the channel members, appending the
+// members to remove to the users so the job input holds every change to apply. The users are matched
+// with the channel users fetched by page, listed users that are not members are counted as users to
+// add without looking them up.
+func (e *Engine) loadSyncDiff(config *Config) *perror.PError {
+	if !config.isSync() {
+		return nil
+	}
+
+	members, appErr := e.getAllChannelMembers(config.ChannelID)
+	if appErr != nil {
+		e.API.LogError("error getting channel members", "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+		return perror.NewInternalServerPError(fmt.Errorf("error getting channel members: %w", appErr))
+	}
+
+	channelUsers, appErr := e.getAllChannelUsers(config.ChannelID)
+	if appErr != nil {
+		e.API.LogError("error getting channel users", "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+		return perror.NewInternalServerPError(fmt.Errorf("error getting channel users: %w", appErr))
+	}
+
+	isMember := make(map[string]bool, len(members))
+	for _, member := range members {
+		isMember[member.UserId] = true
+	}
+
+	usersByID := make(map[string]*model.User, len(channelUsers))
+	memberByUsername := make(map[string]string, len(channelUsers))
+	memberByEmail := make(map[string]string, len(channelUsers))
+	for _, user := range channelUsers {
+		if !isMember[user.Id] {
+			continue
+		}
+		usersByID[user.Id] = user
+		memberByUsername[normalizeUsername(user.Username)] = user.Id
+		memberByEmail[strings.ToLower(user.Email)] = user.Id
+	}
+
+	diff := &SyncDiff{}
+	users := make([]AddUser, 0, len(config.Users))
+	listed := map[string]bool{}
+	toAdd := map[string]bool{}
+	for _, user := range config.Users {
+		user.Remove = false
+		users = append(users, user)
+
+		var memberID, key string
+		switch {
+		case user.UserID != "":
+			if isMember[user.UserID] {
+				memberID = user.UserID
+			}
+			key = "user_id:" + user.UserID
+		case user.Username != "":
+			memberID = memberByUsername[normalizeUsername(user.Username)]
+			key = "username:" + normalizeUsername(user.Username)
+		case user.Email != "":
+			memberID = memberByEmail[strings.ToLower(strings.TrimSpace(user.Email))]
+			key = "email:" + strings.ToLower(strings.TrimSpace(user.Email))
+		default:
+			continue
+		}
+
+		switch {
+		case memberID != "" && !listed[memberID]:
+			listed[memberID] = true
+			diff.Unchanged++
+		case memberID == "" && !toAdd[key]:
+			toAdd[key] = true
+			diff.ToAdd++
+		}
+	}
+
+	for _, member := range members {
+		if listed[member.UserId] {
+			continue
+		}
+
+		if !config.RemoveExtras {
+			diff.Kept++
+			continue
+		}
+
+		protected, appErr := e.isProtectedMember(config, member, usersByID[member.UserId])
+		if appErr != nil {
+			e.API.LogError("error getting user information", "remove_user_id", member.UserId, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+			return perror.NewInternalServerPError(fmt.Errorf("error getting user: %w", appErr))
+		}
+		if protected {
+			diff.Kept++
+			continue
+		}
+
+		users = append(users, AddUser{UserID: member.UserId, Remove: true})
+		diff.ToRemove++
+	}
+
+	config.Users = users
+	config.syncDiff = diff
+
+	return nil
+}
+
+// loadJobSyncDiff computes the diff of a sync job when it starts, storing the members to remove in
+// the job input so they are kept if the job is resumed
+func (e *Engine) loadJobSyncDiff(config *Config, job *Job) error {
+	if perr := e.loadSyncDiff(config); perr != nil {
+		return perr
+	}
+
+	if err := e.jobStore.SaveJobInput(job.ID, config.Users); err != nil {
+		e.API.LogError("error storing job input", "job_id", job.ID, "channel_id", config.ChannelID, "err", err.Error())
+		return fmt.Errorf("error storing job input: %w", err)
+	}
+
+	job.SyncDiff = config.syncDiff
+	job.TotalUsers = len(config.Users)
+	e.saveJob(job)
+
+	return nil
+}
+
+// getAllChannelMembers pages through the members of the channel
+func (e *Engine) getAllChannelMembers(channelID string) ([]model.ChannelMember, *model.AppError) {
+	members := []model.ChannelMember{}
+	for page := 0; ; page++ {
+		pageMembers, appErr := e.API.GetChannelMembers(channelID, page, channelMembersPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		members = append(members, pageMembers...)
+
+		if len(pageMembers) < channelMembersPerPage {
+			return members, nil
+		}
+	}
+}
+
+// getAllChannelUsers pages through the users of the channel
+func (e *Engine) getAllChannelUsers(channelID string) ([]*model.User, *model.AppError) {
+	users := []*model.User{}
+	for page := 0; ; page++ {
+		pageUsers, appErr := e.API.GetUsersInChannel(channelID, "username", page, channelMembersPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		users = append(users, pageUsers...)
+
+		if len(pageUsers) < channelMembersPerPage {
+			return users, nil
+		}
+	}
+}
+
+// isProtectedMember returns true for the members a sync never removes: the user running the sync,
+// channel admins, system admins and bots. The user is looked up if it's not provided.
+func (e *Engine) isProtectedMember(config *Config, member model.ChannelMember, user *model.User) (bool, *model.AppError) {
+	if member.UserId == config.UserID || member.SchemeAdmin {
+		return true, nil
+	}
+
+	if user == nil {
+		var appErr *model.AppError
+		user, appErr = e.API.GetUser(member.UserId)
+		if appErr != nil {
+			return false, appErr
+		}
+	}
+
+	return user.IsBot || user.IsSystemAdmin(), nil
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// undoMembership the memberships of a user created by a job in a channel
+type undoMembership struct {
+	channel bool
+	team    bool
+}
+
+// undoRecords the memberships created by a job, by channel ID and user ID
+type undoRecords map[string]map[string]undoMembership
+
+// newUndoRecords reads the memberships created by a job from its report, returning them along with
+// the users to process in the order they were added. Results without channel belong to single
+// channel jobs.
+func newUndoRecords(jobChannelID string, report Report) (undoRecords, []AddUser) {
+	records := undoRecords{}
+	users := []AddUser{}
+	seen := map[string]bool{}
+	for _, userResult := range report {
+		if userResult.UserID == "" || (userResult.Outcome != OutcomeAdded && !userResult.AddedToTeam) {
+			continue
+		}
+
+		channelID := userResult.ChannelID
+		if channelID == "" {
+			channelID = jobChannelID
+		}
+		if records[channelID] == nil {
+			records[channelID] = map[string]undoMembership{}
+		}
+		records[channelID][userResult.UserID] = undoMembership{
+			channel: userResult.Outcome == OutcomeAdded,
+			team:    userResult.AddedToTeam,
+		}
+
+		if !seen[userResult.UserID] {
+			seen[userResult.UserID] = true
+			users = append(users, AddUser{UserID: userResult.UserID})
+		}
+	}
+
+	return records, users
+}
+
+// UndoJob starts a job removing the channel and team memberships created by a finished add job
+func (e *Engine) UndoJob(ctx context.Context, job *Job, userID string) (*Job, *perror.PError) {
+	if job.Operation != OperationAdd {
+		return nil, perror.NewPError(fmt.Errorf("undo_not_supported"), "Only bulk add jobs can be undone.")
+	}
+
+	if !job.IsFinished() {
+		return nil, perror.NewPError(fmt.Errorf("job_not_finished"), fmt.Sprintf("Job `%s` has not finished yet.", job.ID))
+	}
+
+	if job.UndoJobID != "" {
+		return nil, perror.NewPError(fmt.Errorf("job_already_undone"), fmt.Sprintf("Job `%s` has already been undone by job `%s`.", job.ID, job.UndoJobID))
+	}
+
+	settings, _ := e.getSettings()
+	if settings.UndoWindowHours <= 0 {
+		return nil, perror.NewPError(fmt.Errorf("undo_disabled"), "Undoing bulk jobs is disabled.")
+	}
+
+	if time.Since(time.UnixMilli(job.FinishAt)) > time.Duration(settings.UndoWindowHours)*time.Hour {
+		return nil, perror.NewPError(
+			fmt.Errorf("undo_window_expired"),
+			fmt.Sprintf("Job `%s` finished more than %d hours ago and can no longer be undone.", job.ID, settings.UndoWindowHours),
+		)
+	}
+
+	undoJob, perr := e.StartJob(ctx, &Config{
+		Operation:   OperationUndo,
+		ChannelID:   job.ChannelID,
+		ChannelIDs:  job.ChannelIDs,
+		UserID:      userID,
+		UndoOfJobID: job.ID,
+	})
+	if perr != nil {
+		return nil, perr
+	}
+
+	job.UndoJobID = undoJob.ID
+	e.saveJob(job)
+
+	return undoJob, nil
+}
+
+// loadUndoRecords loads the memberships created by the job being undone, setting them as the users
+// of new undo jobs. The user needs permission to remove users from the teams the job added users to.
+func (e *Engine) loadUndoRecords(config *Config) *perror.PError {
+	report, err := e.jobStore.GetJobReport(config.UndoOfJobID)
+	if err != nil {
+		e.API.LogError("error loading job report", "job_id", config.UndoOfJobID, "err", err.Error())
+		return perror.NewInternalServerPError(fmt.Errorf("error loading report of job %s: %w", config.UndoOfJobID, err))
+	}
+
+	records, users := newUndoRecords(config.ChannelID, report)
+	if len(users) == 0 {
+		return perror.NewPError(fmt.Errorf("nothing_to_undo"), fmt.Sprintf("Job `%s` didn't add any user.", config.UndoOfJobID))
+	}
+
+	records.deferTeamRemovals(config.channels)
+
+	for _, channel := range config.channels {
+		if !records.hasTeamMemberships(channel.Id) {
+			continue
+		}
+
+		if !e.API.HasPermissionToTeam(config.UserID, channel.TeamId, model.PermissionRemoveUserFromTeam) {
+			return perror.NewPError(fmt.Errorf("insufficient_team_permissions__remove_user"), "You dont have enough permissions to remove users from this team")
+		}
+	}
+
+	config.undo = records
+	if len(config.Users) == 0 {
+		config.Users = users
+	}
+
+	return nil
+}
+
+// deferTeamRemovals moves the team memberships to the last channel of the team processed by the job.
+// Removing a user from the team removes it from every channel of the team, so it's done once the
+// memberships of the other channels are undone.
+func (r undoRecords) deferTeamRemovals(channels []*model.Channel) {
+	lastTeamChannel := map[string]string{}
+	for _, channel := range channels {
+		lastTeamChannel[channel.TeamId] = channel.Id
+	}
+
+	for _, channel := range channels {
+		lastChannelID := lastTeamChannel[channel.TeamId]
+		if lastChannelID == channel.Id {
+			continue
+		}
+
+		for userID, membership := range r[channel.Id] {
+			if !membership.team {
+				continue
+			}
+
+			membership.team = false
+			r[channel.Id][userID] = membership
+
+			if r[lastChannelID] == nil {
+				r[lastChannelID] = map[string]undoMembership{}
+			}
+			lastMembership := r[lastChannelID][userID]
+			lastMembership.team = true
+			r[lastChannelID][userID] = lastMembership
+		}
+	}
+}
+
+// hasTeamMemberships returns true if the job added users to the team while adding them to the channel
+func (r undoRecords) hasTeamMemberships(channelID string) bool {
+	for _, membership := range r[channelID] {
+		if membership.team {
+			return true
+		}
+	}
+	return false
+}
+
+// undoUser removes the memberships of a resolved user created by the job being undone. Users that
+// already left the channel are not members, and users the job didn't add to the channel are skipped.
+// The team membership is removed after the channel one, in the last channel of the team.
+func (e *Engine) undoUser(ctx context.Context, config *Config, user *model.User, channelMembers map[string]bool, limiter *rateLimiter) UserResult {
+	result := UserResult{UserID: user.Id, Outcome: OutcomeNotMember}
+	membership := config.undo[config.ChannelID][user.Id]
+	if !membership.channel {
+		result.Outcome = OutcomeNotAddedByJob
+	}
+
+	if membership.channel && (channelMembers == nil || channelMembers[user.Id]) {
+		if limiter.Wait(ctx) != nil {
+			return newInterruptedUserResult(user.Id)
+		}
+		appErr := e.API.DeleteChannelMember(config.ChannelID, user.Id)
+		switch {
+		case appErr == nil:
+			result.Outcome = OutcomeRemoved
+		case appErr.StatusCode != http.StatusNotFound:
+			e.API.LogError("error removing user from channel", "remove_user_id", user.Id, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "err", appErr.Error())
+			return newErrorUserResult(user.Id, fmt.Errorf("error removing user from channel: %w", appErr))
+		}
+	}
+
+	if membership.team {
+		if err := limiter.Wait(ctx); err != nil {
+			return newErrorUserResult(user.Id, fmt.Errorf("job stopped before removing the user from the team: %w", err))
+		}
+		if appErr := e.API.DeleteTeamMember(config.channel.TeamId, user.Id, config.UserID); appErr != nil {
+			e.API.LogError("error removing user from team", "remove_user_id", user.Id, "trigger_user_id", config.UserID, "channel_id", config.ChannelID, "team_id", config.channel.TeamId, "err", appErr.Error())
+			return newErrorUserResult(user.Id, fmt.Errorf("error removing user from team: %w", appErr))
+		}
+		result.RemovedFromTeam = true
+	}
+
+	return result
+}
@@ -11,23 +11,44 @@ import (
 	"github.com/jellydator/ttlcache/v3"
 )
 
+// CacheInvalidationEventID the ID of the cluster events invalidating a key cached by other nodes
+const CacheInvalidationEventID = "kvstore_cache_invalidation"
+
+// ClusterAPI isolates the plugin API methods used to keep the caches of the cluster nodes coherent
+type ClusterAPI interface {
+	PublishPluginClusterEvent(ev model.PluginClusterEvent, opts model.PluginClusterEventSendOptions) error
+	LogError(msg string, keyValuePairs ...any)
+}
+
+// CacheKVStore is a KVStore caching the loaded values in the node. Every write invalidates the key
+// in the other nodes of the cluster, which must forward the received events to HandleClusterEvent.
+type CacheKVStore interface {
+	KVStore
+	HandleClusterEvent(ev model.PluginClusterEvent)
+}
+
 type cacheKeyStore struct {
-	store KVStore
-	ttl   time.Duration
-	cache *ttlcache.Cache[string, []byte]
+	store   KVStore
+	ttl     time.Duration
+	cache   *ttlcache.Cache[string, []byte]
+	cluster ClusterAPI
 }
 
-var _ KVStore = (*cacheKeyStore)(nil)
+var _ CacheKVStore = (*cacheKeyStore)(nil)
 
-func NewCacheKeyStore(s KVStore, ttl time.Duration) KVStore {
+func NewCacheKeyStore(s KVStore, ttl time.Duration, cluster ClusterAPI) CacheKVStore {
 	cache := ttlcache.New[string, []byte](
 		ttlcache.WithTTL[string, []byte](ttl),
+		// Values read often must expire too, in case an invalidation from another node is lost
+		ttlcache.WithDisableTouchOnHit[string, []byte](),
 	)
 	go cache.Start() // Expiration job
 
 	return &cacheKeyStore{
-		store: s,
-		cache: cache,
+		store:   s,
+		ttl:     ttl,
+		cache:   cache,
+		cluster: cluster,
 	}
 }
 
@@ -48,6 +69,27 @@ func (s cacheKeyStore) loadCache(key string) ([]byte, bool) {
 	return item.Value(), true
 }
 
+// invalidate removes the key from the cache of this node and the other nodes of the cluster
+func (s cacheKeyStore) invalidate(key string) {
+	s.cache.Delete(key)
+
+	if err := s.cluster.PublishPluginClusterEvent(
+		model.PluginClusterEvent{Id: CacheInvalidationEventID, Data: []byte(key)},
+		model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable},
+	); err != nil {
+		s.cluster.LogError("error publishing cache invalidation", "key", key, "err", err.Error())
+	}
+}
+
+// HandleClusterEvent removes from the cache the keys written by other nodes
+func (s cacheKeyStore) HandleClusterEvent(ev model.PluginClusterEvent) {
+	if ev.Id != CacheInvalidationEventID {
+		return
+	}
+
+	s.cache.Delete(string(ev.Data))
+}
+
 func (s cacheKeyStore) Load(key string) ([]byte, error) {
 	if value, exists := s.loadCache(key); exists {
 		return value, nil
@@ -61,28 +103,63 @@ func (s cacheKeyStore) Load(key string) ([]byte, error) {
 	return value, err
 }
 
+// Store writes the value before invalidating the key, so other nodes can't load the previous value
+// again once they receive the invalidation. The same applies to the other writes.
 func (s cacheKeyStore) Store(key string, value []byte) error {
-	s.storeCache(key, value)
+	if err := s.store.Store(key, value); err != nil {
+		s.cache.Delete(key)
+		return err
+	}
 
-	return s.store.Store(key, value)
+	s.invalidate(key)
+	s.storeCache(key, value)
+	return nil
 }
 
 func (s cacheKeyStore) StoreTTL(key string, value []byte, ttlSeconds int64) error {
-	s.storeCacheTTL(key, value, time.Duration(ttlSeconds)*time.Second)
+	if err := s.store.StoreTTL(key, value, ttlSeconds); err != nil {
+		s.cache.Delete(key)
+		return err
+	}
 
-	return s.store.StoreTTL(key, value, ttlSeconds)
+	s.invalidate(key)
+	s.storeCacheTTL(key, value, s.cacheTTL(ttlSeconds))
+	return nil
 }
 
 func (s cacheKeyStore) StoreWithOptions(key string, value []byte, opts model.PluginKVSetOptions) (bool, error) {
-	s.storeCache(key, value)
-	return s.store.StoreWithOptions(key, value, opts)
+	success, err := s.store.StoreWithOptions(key, value, opts)
+	if err != nil || !success {
+		// The cached value may be outdated if the atomic write failed
+		s.cache.Delete(key)
+		return success, err
+	}
+
+	s.invalidate(key)
+	if value != nil {
+		s.storeCacheTTL(key, value, s.cacheTTL(opts.ExpireInSeconds))
+	}
+	return true, nil
+}
+
+// cacheTTL returns the cache TTL of a value expiring in the store, so it's not cached after it expires
+func (s cacheKeyStore) cacheTTL(ttlSeconds int64) time.Duration {
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if ttl <= 0 || ttl > s.ttl {
+		return s.ttl
+	}
+	return ttl
 }
 
 func (s cacheKeyStore) Delete(key string) error {
-	s.cache.Delete(key)
-	return s.store.Delete(key)
+	err := s.store.Delete(key)
+	s.invalidate(key)
+	return err
 }
 
 func (s cacheKeyStore) Exists(key string) bool {
-	return s.cache.Has(key)
+	if s.cache.Has(key) {
+		return true
+	}
+	return s.store.Exists(key)
 }
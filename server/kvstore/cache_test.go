@@ -1 +1,268 @@
 package kvstore
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is the KVStore shared by the nodes of a cluster
+type memoryStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	loads  int
+}
+
+var _ KVStore = (*memoryStore)(nil)
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{values: map[string][]byte{}}
+}
+
+func (s *memoryStore) Load(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads++
+	value, ok := s.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *memoryStore) Store(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = data
+	return nil
+}
+
+func (s *memoryStore) StoreTTL(key string, data []byte, _ int64) error {
+	return s.Store(key, data)
+}
+
+func (s *memoryStore) StoreWithOptions(key string, value []byte, opts model.PluginKVSetOptions) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.values[key]
+	if opts.Atomic {
+		if opts.OldValue == nil && exists {
+			return false, nil
+		}
+		if opts.OldValue != nil && (!exists || !bytes.Equal(current, opts.OldValue)) {
+			return false, nil
+		}
+	}
+
+	if value == nil {
+		delete(s.values, key)
+	} else {
+		s.values[key] = value
+	}
+	return true, nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memoryStore) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.values[key]
+	return ok
+}
+
+// fakeCluster delivers the events published by a node to every other node, like the server does
+type fakeCluster struct {
+	mu    sync.Mutex
+	nodes []CacheKVStore
+}
+
+type fakeClusterNode struct {
+	cluster *fakeCluster
+	index   int
+}
+
+func (n *fakeClusterNode) PublishPluginClusterEvent(ev model.PluginClusterEvent, _ model.PluginClusterEventSendOptions) error {
+	n.cluster.mu.Lock()
+	defer n.cluster.mu.Unlock()
+
+	for i, node := range n.cluster.nodes {
+		if i != n.index {
+			node.HandleClusterEvent(ev)
+		}
+	}
+	return nil
+}
+
+func (n *fakeClusterNode) LogError(string, ...any) {}
+
+// newFakeCluster returns the cached stores of a cluster of nodes sharing the same store
+func newFakeCluster(store KVStore, nodes int, ttl time.Duration) []CacheKVStore {
+	cluster := &fakeCluster{}
+	for i := 0; i < nodes; i++ {
+		cluster.nodes = append(cluster.nodes, NewCacheKeyStore(store, ttl, &fakeClusterNode{cluster: cluster, index: i}))
+	}
+	return cluster.nodes
+}
+
+func TestCacheKeyStore(t *testing.T) {
+	t.Run("loaded values should be cached", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 1, time.Minute)
+
+		require.NoError(t, store.Store("key", []byte("value")))
+
+		for i := 0; i < 3; i++ {
+			value, err := nodes[0].Load("key")
+			require.NoError(t, err)
+			require.Equal(t, []byte("value"), value)
+		}
+		require.Equal(t, 1, store.loads)
+	})
+
+	t.Run("cached values should expire after the ttl", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 1, 50*time.Millisecond)
+
+		require.NoError(t, nodes[0].Store("key", []byte("value")))
+		require.NoError(t, store.Store("key", []byte("updated")))
+
+		value, err := nodes[0].Load("key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+
+		require.Eventually(t, func() bool {
+			value, err := nodes[0].Load("key")
+			return err == nil && bytes.Equal(value, []byte("updated"))
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("exists should check the store when the key is not cached", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 1, time.Minute)
+
+		require.False(t, nodes[0].Exists("key"))
+
+		require.NoError(t, store.Store("key", []byte("value")))
+		require.True(t, nodes[0].Exists("key"))
+	})
+
+	t.Run("failed atomic writes should not be cached", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 1, time.Minute)
+
+		require.NoError(t, store.Store("key", []byte("value")))
+
+		stored, err := nodes[0].StoreWithOptions("key", []byte("other"), model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+		require.NoError(t, err)
+		require.False(t, stored)
+
+		value, err := nodes[0].Load("key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+	})
+
+	t.Run("atomic deletes should remove the cached value", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 1, time.Minute)
+
+		require.NoError(t, nodes[0].Store("key", []byte("value")))
+
+		deleted, err := nodes[0].StoreWithOptions("key", nil, model.PluginKVSetOptions{Atomic: true, OldValue: []byte("value")})
+		require.NoError(t, err)
+		require.True(t, deleted)
+
+		_, err = nodes[0].Load("key")
+		require.ErrorIs(t, err, ErrNotFound)
+		require.False(t, nodes[0].Exists("key"))
+	})
+}
+
+func TestCacheKeyStoreCluster(t *testing.T) {
+	t.Run("writes should invalidate the values cached by other nodes", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 3, time.Minute)
+
+		require.NoError(t, nodes[0].Store("key", []byte("first")))
+		for _, node := range nodes {
+			value, err := node.Load("key")
+			require.NoError(t, err)
+			require.Equal(t, []byte("first"), value)
+		}
+
+		require.NoError(t, nodes[1].Store("key", []byte("second")))
+		for _, node := range nodes {
+			value, err := node.Load("key")
+			require.NoError(t, err)
+			require.Equal(t, []byte("second"), value)
+		}
+
+		stored, err := nodes[2].StoreWithOptions("key", []byte("third"), model.PluginKVSetOptions{Atomic: true, OldValue: []byte("second")})
+		require.NoError(t, err)
+		require.True(t, stored)
+		for _, node := range nodes {
+			value, err := node.Load("key")
+			require.NoError(t, err)
+			require.Equal(t, []byte("third"), value)
+		}
+	})
+
+	t.Run("deletes should invalidate the values cached by other nodes", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 2, time.Minute)
+
+		require.NoError(t, nodes[0].Store("key", []byte("value")))
+		require.True(t, nodes[1].Exists("key"))
+		_, err := nodes[1].Load("key")
+		require.NoError(t, err)
+
+		require.NoError(t, nodes[0].Delete("key"))
+
+		_, err = nodes[1].Load("key")
+		require.ErrorIs(t, err, ErrNotFound)
+		require.False(t, nodes[1].Exists("key"))
+	})
+
+	t.Run("failed atomic writes should not invalidate other nodes", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 2, time.Minute)
+
+		require.NoError(t, nodes[0].Store("key", []byte("value")))
+		_, err := nodes[1].Load("key")
+		require.NoError(t, err)
+		loads := store.loads
+
+		stored, err := nodes[0].StoreWithOptions("key", []byte("other"), model.PluginKVSetOptions{Atomic: true, OldValue: []byte("outdated")})
+		require.NoError(t, err)
+		require.False(t, stored)
+
+		value, err := nodes[1].Load("key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+		require.Equal(t, loads, store.loads)
+	})
+
+	t.Run("other plugin events should be ignored", func(t *testing.T) {
+		store := newMemoryStore()
+		nodes := newFakeCluster(store, 1, time.Minute)
+
+		require.NoError(t, nodes[0].Store("key", []byte("value")))
+		loads := store.loads
+
+		nodes[0].HandleClusterEvent(model.PluginClusterEvent{Id: "other_event", Data: []byte("key")})
+
+		_, err := nodes[0].Load("key")
+		require.NoError(t, err)
+		require.Equal(t, loads, store.loads)
+	})
+}
@@ -4,26 +4,69 @@
 package kvstore
 
 import (
+	"bytes"
+	"encoding/json"
 	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/pkg/errors"
 )
 
+const (
+	// LockHeartbeatInterval how often the owner of a lock must renew it while it's working
+	LockHeartbeatInterval = time.Minute
+
+	// StaleLockThreshold the time without heartbeats after which a lock is considered abandoned
+	StaleLockThreshold = 3 * LockHeartbeatInterval
+
+	// lockTTL locks not renewed expire, so an abandoned lock is released shortly after its owner stops
+	lockTTL = 5 * LockHeartbeatInterval
+)
+
 var (
-	lockedValue = []byte("")
-	lockTTL     = 30 * time.Minute
-	ErrIsLocked = errors.New("item is locked")
+	ErrIsLocked       = errors.New("item is locked")
+	ErrNotLockOwner   = errors.New("item is locked by another owner")
+	ErrLockNotStale   = errors.New("lock is not stale")
+	errEmptyLockOwner = errors.New("lock owner is required")
 )
 
 func getLockKey(key string) string {
 	return "lock_" + key
 }
 
+// LockInfo the metadata stored as the value of a lock
+type LockInfo struct {
+	// Owner the holder of the lock, usually a job ID
+	Owner string `json:"owner"`
+
+	// LockedAt the time the lock was acquired
+	LockedAt int64 `json:"locked_at"`
+
+	// HeartbeatAt the last time the owner renewed the lock
+	HeartbeatAt int64 `json:"heartbeat_at"`
+}
+
+// IsStale returns true if the owner stopped renewing the lock
+func (l *LockInfo) IsStale() bool {
+	return time.Since(time.UnixMilli(l.HeartbeatAt)) > StaleLockThreshold
+}
+
+// LockStore locks items across the nodes of a cluster. The lock value holds the owner, usually the
+// ID of the job holding the lock, and only the owner can release it.
 type LockStore interface {
-	Lock(key string) error
-	Unlock(key string) error
+	Lock(key, owner string) error
+	Unlock(key, owner string) error
 	IsLocked(key string) bool
+
+	// Renew extends the lease of a lock held by the owner
+	Renew(key, owner string) error
+
+	// GetLock returns the metadata of a lock, ErrNotFound if the item is not locked
+	GetLock(key string) (*LockInfo, error)
+
+	// ReleaseStale releases a lock regardless of its owner, only if the owner stopped renewing it
+	ReleaseStale(key string) error
 }
 
 type lockStore struct {
@@ -31,24 +74,144 @@ type lockStore struct {
 	ttl   time.Duration
 }
 
-func (s *lockStore) Lock(key string) error {
-	if s.IsLocked(key) {
+// Lock acquires the lock if no one holds it, in a single atomic write so two nodes can't both
+// acquire it. Expired locks are considered free.
+func (s *lockStore) Lock(key, owner string) error {
+	if owner == "" {
+		return errEmptyLockOwner
+	}
+
+	now := model.GetMillis()
+	value, err := json.Marshal(&LockInfo{Owner: owner, LockedAt: now, HeartbeatAt: now})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal lock")
+	}
+
+	acquired, err := s.store.StoreWithOptions(getLockKey(key), value, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(s.ttl / time.Second),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to acquire lock")
+	}
+	if !acquired {
 		return ErrIsLocked
 	}
-	return s.store.StoreTTL(getLockKey(key), lockedValue, int64(s.ttl))
+	return nil
+}
+
+// Unlock releases the lock if it's held by the owner. Releasing a lock that already expired is not
+// an error.
+func (s *lockStore) Unlock(key, owner string) error {
+	current, info, err := s.load(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Owner != owner {
+		return ErrNotLockOwner
+	}
+
+	return s.release(key, current)
 }
 
-func (s *lockStore) Unlock(key string) error {
-	return s.store.Delete(getLockKey(key))
+func (s *lockStore) Renew(key, owner string) error {
+	current, info, err := s.load(key)
+	if errors.Is(err, ErrNotFound) {
+		// The lock expired, acquire it again unless someone else did
+		return s.Lock(key, owner)
+	}
+	if err != nil {
+		return err
+	}
+	if info.Owner != owner {
+		return ErrNotLockOwner
+	}
+
+	info.HeartbeatAt = model.GetMillis()
+	value, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal lock")
+	}
+
+	renewed, err := s.store.StoreWithOptions(getLockKey(key), value, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        current,
+		ExpireInSeconds: int64(s.ttl / time.Second),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to renew lock")
+	}
+	if !renewed {
+		return ErrNotLockOwner
+	}
+	return nil
 }
 
 func (s *lockStore) IsLocked(key string) bool {
 	return s.store.Exists(getLockKey(key))
 }
 
+func (s *lockStore) GetLock(key string) (*LockInfo, error) {
+	_, info, err := s.load(key)
+	return info, err
+}
+
+func (s *lockStore) ReleaseStale(key string) error {
+	current, info, err := s.load(key)
+	if err != nil {
+		return err
+	}
+	if !info.IsStale() {
+		return ErrLockNotStale
+	}
+
+	return s.release(key, current)
+}
+
+// load returns the stored value of a lock along with its metadata. Locks stored by previous
+// versions have no metadata and are reported as stale.
+func (s *lockStore) load(key string) ([]byte, *LockInfo, error) {
+	value, err := s.store.Load(getLockKey(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &LockInfo{}
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, info); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to unmarshal lock")
+		}
+	}
+	return value, info, nil
+}
+
+// release deletes the lock if its value didn't change since it was loaded
+func (s *lockStore) release(key string, current []byte) error {
+	released, err := s.store.StoreWithOptions(getLockKey(key), nil, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: current,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to release lock")
+	}
+	if !released {
+		// Renewed or released concurrently
+		if latest, _, err := s.load(key); err == nil && !bytes.Equal(latest, current) {
+			return ErrNotLockOwner
+		}
+	}
+	return nil
+}
+
+// NewLockStore returns a lock store backed by the plugin KV store. It's not cached, since the
+// locks are shared by every node.
 func NewLockStore(api plugin.API) LockStore {
 	return &lockStore{
-		store: NewCacheKeyStore(NewPluginStore(api), 30*time.Second),
+		store: NewPluginStore(api),
 		ttl:   lockTTL,
 	}
 }
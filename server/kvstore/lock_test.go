@@ -0,0 +1,285 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKV is the KV store shared by the nodes of a cluster, applying atomic writes the way the
+// server does
+type memoryKV struct {
+	mu      sync.Mutex
+	values  map[string][]byte
+	expires map[string]int64
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{
+		values:  map[string][]byte{},
+		expires: map[string]int64{},
+	}
+}
+
+func (kv *memoryKV) get(key string) ([]byte, *model.AppError) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.values[key], nil
+}
+
+func (kv *memoryKV) setWithOptions(key string, value []byte, opts model.PluginKVSetOptions) (bool, *model.AppError) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	current, exists := kv.values[key]
+	if opts.Atomic {
+		if opts.OldValue == nil && exists {
+			return false, nil
+		}
+		if opts.OldValue != nil && (!exists || !bytes.Equal(current, opts.OldValue)) {
+			return false, nil
+		}
+	}
+
+	if value == nil {
+		delete(kv.values, key)
+		delete(kv.expires, key)
+		return true, nil
+	}
+
+	kv.values[key] = value
+	kv.expires[key] = opts.ExpireInSeconds
+	return true, nil
+}
+
+// newTestNode returns the lock store of a cluster node using the shared KV store
+func newTestNode(t *testing.T, kv *memoryKV) LockStore {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+
+	api.On("KVGet", mock.AnythingOfType("string")).Return(kv.get).Maybe()
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(kv.setWithOptions).Maybe()
+
+	return NewLockStore(api)
+}
+
+func TestLockStore(t *testing.T) {
+	t.Run("only the owner should unlock", func(t *testing.T) {
+		kv := newMemoryKV()
+		store := newTestNode(t, kv)
+
+		require.NoError(t, store.Lock("channel-id", "job-1"))
+		require.True(t, store.IsLocked("channel-id"))
+
+		lock, err := store.GetLock("channel-id")
+		require.NoError(t, err)
+		require.Equal(t, "job-1", lock.Owner)
+		require.NotZero(t, lock.LockedAt)
+		require.False(t, lock.IsStale())
+
+		require.ErrorIs(t, store.Lock("channel-id", "job-2"), ErrIsLocked)
+		require.ErrorIs(t, store.Unlock("channel-id", "job-2"), ErrNotLockOwner)
+		require.True(t, store.IsLocked("channel-id"))
+
+		require.NoError(t, store.Unlock("channel-id", "job-1"))
+		require.False(t, store.IsLocked("channel-id"))
+
+		require.NoError(t, store.Lock("channel-id", "job-2"))
+	})
+
+	t.Run("unlocking an expired lock should succeed", func(t *testing.T) {
+		kv := newMemoryKV()
+		store := newTestNode(t, kv)
+
+		require.NoError(t, store.Unlock("channel-id", "job-1"))
+	})
+
+	t.Run("locks should expire after the ttl in seconds", func(t *testing.T) {
+		kv := newMemoryKV()
+		store := newTestNode(t, kv)
+
+		require.NoError(t, store.Lock("channel-id", "job-1"))
+		require.Equal(t, int64(5*60), kv.expires[getLockKey("channel-id")])
+	})
+
+	t.Run("renewing should update the heartbeat", func(t *testing.T) {
+		kv := newMemoryKV()
+		store := newTestNode(t, kv)
+
+		require.NoError(t, store.Lock("channel-id", "job-1"))
+		setHeartbeat(t, kv, "channel-id", time.Minute)
+
+		require.ErrorIs(t, store.Renew("channel-id", "job-2"), ErrNotLockOwner)
+		require.NoError(t, store.Renew("channel-id", "job-1"))
+
+		lock, err := store.GetLock("channel-id")
+		require.NoError(t, err)
+		require.Equal(t, "job-1", lock.Owner)
+		require.Greater(t, lock.HeartbeatAt, lock.LockedAt)
+		require.Equal(t, int64(lockTTL/time.Second), kv.expires[getLockKey("channel-id")])
+	})
+
+	t.Run("renewing an expired lock should lock it again", func(t *testing.T) {
+		kv := newMemoryKV()
+		store := newTestNode(t, kv)
+
+		require.NoError(t, store.Renew("channel-id", "job-1"))
+
+		lock, err := store.GetLock("channel-id")
+		require.NoError(t, err)
+		require.Equal(t, "job-1", lock.Owner)
+
+		require.ErrorIs(t, store.Renew("channel-id", "job-2"), ErrNotLockOwner)
+	})
+
+	t.Run("only stale locks should be released", func(t *testing.T) {
+		kv := newMemoryKV()
+		store := newTestNode(t, kv)
+
+		require.ErrorIs(t, store.ReleaseStale("channel-id"), ErrNotFound)
+
+		require.NoError(t, store.Lock("channel-id", "job-1"))
+		require.ErrorIs(t, store.ReleaseStale("channel-id"), ErrLockNotStale)
+		require.True(t, store.IsLocked("channel-id"))
+
+		setHeartbeat(t, kv, "channel-id", StaleLockThreshold+time.Minute)
+
+		lock, err := store.GetLock("channel-id")
+		require.NoError(t, err)
+		require.True(t, lock.IsStale())
+
+		require.NoError(t, store.ReleaseStale("channel-id"))
+		require.False(t, store.IsLocked("channel-id"))
+		require.NoError(t, store.Lock("channel-id", "job-2"))
+	})
+
+	t.Run("locks without owner should fail", func(t *testing.T) {
+		kv := newMemoryKV()
+		store := newTestNode(t, kv)
+
+		require.Error(t, store.Lock("channel-id", ""))
+		require.False(t, store.IsLocked("channel-id"))
+	})
+}
+
+func TestLockStoreConcurrently(t *testing.T) {
+	const (
+		nodes    = 3
+		attempts = 20
+	)
+
+	kv := newMemoryKV()
+	stores := make([]LockStore, nodes)
+	for i := range stores {
+		stores[i] = newTestNode(t, kv)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		owners   []string
+		lockErrs []error
+	)
+	for i := 0; i < nodes; i++ {
+		for j := 0; j < attempts; j++ {
+			wg.Add(1)
+			go func(store LockStore, owner string) {
+				defer wg.Done()
+
+				err := store.Lock("channel-id", owner)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					lockErrs = append(lockErrs, err)
+					return
+				}
+				owners = append(owners, owner)
+			}(stores[i], fmt.Sprintf("job-%d-%d", i, j))
+		}
+	}
+	wg.Wait()
+
+	for _, err := range lockErrs {
+		require.ErrorIs(t, err, ErrIsLocked)
+	}
+	require.Len(t, owners, 1)
+	lock, err := stores[0].GetLock("channel-id")
+	require.NoError(t, err)
+	require.Equal(t, owners[0], lock.Owner)
+
+	// Other nodes can't release the lock
+	for _, store := range stores {
+		require.ErrorIs(t, store.Unlock("channel-id", "job-other"), ErrNotLockOwner)
+	}
+	require.NoError(t, stores[nodes-1].Unlock("channel-id", owners[0]))
+	require.False(t, stores[0].IsLocked("channel-id"))
+}
+
+func TestLockStoreRenewConcurrently(t *testing.T) {
+	kv := newMemoryKV()
+	owner := newTestNode(t, kv)
+	other := newTestNode(t, kv)
+
+	require.NoError(t, owner.Lock("channel-id", "job-1"))
+
+	// Renewals of the owner never let another node acquire or release the lock
+	const renewals = 20
+	var wg sync.WaitGroup
+	renewErrs := make(chan error, renewals)
+	lockErrs := make(chan error, renewals)
+	unlockErrs := make(chan error, renewals)
+	for i := 0; i < renewals; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			renewErrs <- owner.Renew("channel-id", "job-1")
+		}()
+		go func() {
+			defer wg.Done()
+			lockErrs <- other.Lock("channel-id", "job-2")
+			unlockErrs <- other.Unlock("channel-id", "job-2")
+		}()
+	}
+	wg.Wait()
+	close(renewErrs)
+	close(lockErrs)
+	close(unlockErrs)
+
+	for err := range renewErrs {
+		require.NoError(t, err)
+	}
+	for err := range lockErrs {
+		require.ErrorIs(t, err, ErrIsLocked)
+	}
+	for err := range unlockErrs {
+		require.ErrorIs(t, err, ErrNotLockOwner)
+	}
+
+	lock, err := other.GetLock("channel-id")
+	require.NoError(t, err)
+	require.Equal(t, "job-1", lock.Owner)
+}
+
+// setHeartbeat moves the last heartbeat of a lock to the past
+func setHeartbeat(t *testing.T, kv *memoryKV, key string, ago time.Duration) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	lock := LockInfo{}
+	require.NoError(t, json.Unmarshal(kv.values[getLockKey(key)], &lock))
+	lock.LockedAt = time.Now().Add(-ago).UnixMilli()
+	lock.HeartbeatAt = lock.LockedAt
+
+	value, err := json.Marshal(lock)
+	require.NoError(t, err)
+	kv.values[getLockKey(key)] = value
+}
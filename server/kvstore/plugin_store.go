@@ -83,5 +83,5 @@ func (s pluginStore) Delete(key string) error {
 
 func (s pluginStore) Exists(key string) bool {
 	_, err := s.Load(key)
-	return !errors.As(err, ErrNotFound)
+	return !errors.Is(err, ErrNotFound)
 }
@@ -1,80 +1,126 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore (interfaces: LockStore)
+// Source: github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore (interfaces: KVStore)
 //
 // Generated by this command:
 //
-//	mockgen -destination=../mocks/mock_kvstore.go -package=mocks github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore LockStore
+//	mockgen -destination=../mocks/mock_kvstore.go -package=mocks github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore KVStore
 //
+
 // Package mocks is a generated GoMock package.
 package mocks
 
 import (
 	reflect "reflect"
 
+	model "github.com/mattermost/mattermost/server/public/model"
 	gomock "go.uber.org/mock/gomock"
 )
 
-// MockLockStore is a mock of LockStore interface.
-type MockLockStore struct {
+// MockKVStore is a mock of KVStore interface.
+type MockKVStore struct {
 	ctrl     *gomock.Controller
-	recorder *MockLockStoreMockRecorder
+	recorder *MockKVStoreMockRecorder
 }
 
-// MockLockStoreMockRecorder is the mock recorder for MockLockStore.
-type MockLockStoreMockRecorder struct {
-	mock *MockLockStore
+// MockKVStoreMockRecorder is the mock recorder for MockKVStore.
+type MockKVStoreMockRecorder struct {
+	mock *MockKVStore
 }
 
-// NewMockLockStore creates a new mock instance.
-func NewMockLockStore(ctrl *gomock.Controller) *MockLockStore {
-	mock := &MockLockStore{ctrl: ctrl}
-	mock.recorder = &MockLockStoreMockRecorder{mock}
+// NewMockKVStore creates a new mock instance.
+func NewMockKVStore(ctrl *gomock.Controller) *MockKVStore {
+	mock := &MockKVStore{ctrl: ctrl}
+	mock.recorder = &MockKVStoreMockRecorder{mock}
 	return mock
 }
 
 // EXPECT returns an object that allows the caller to indicate expected use.
-func (m *MockLockStore) EXPECT() *MockLockStoreMockRecorder {
+func (m *MockKVStore) EXPECT() *MockKVStoreMockRecorder {
 	return m.recorder
 }
 
-// IsLocked mocks base method.
-func (m *MockLockStore) IsLocked(arg0 string) bool {
+// Delete mocks base method.
+func (m *MockKVStore) Delete(arg0 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "IsLocked", arg0)
+	ret := m.ctrl.Call(m, "Delete", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockKVStoreMockRecorder) Delete(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockKVStore)(nil).Delete), arg0)
+}
+
+// Exists mocks base method.
+func (m *MockKVStore) Exists(arg0 string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Exists", arg0)
 	ret0, _ := ret[0].(bool)
 	return ret0
 }
 
-// IsLocked indicates an expected call of IsLocked.
-func (mr *MockLockStoreMockRecorder) IsLocked(arg0 any) *gomock.Call {
+// Exists indicates an expected call of Exists.
+func (mr *MockKVStoreMockRecorder) Exists(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exists", reflect.TypeOf((*MockKVStore)(nil).Exists), arg0)
+}
+
+// Load mocks base method.
+func (m *MockKVStore) Load(arg0 string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Load", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Load indicates an expected call of Load.
+func (mr *MockKVStoreMockRecorder) Load(arg0 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsLocked", reflect.TypeOf((*MockLockStore)(nil).IsLocked), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockKVStore)(nil).Load), arg0)
 }
 
-// Lock mocks base method.
-func (m *MockLockStore) Lock(arg0 string) error {
+// Store mocks base method.
+func (m *MockKVStore) Store(arg0 string, arg1 []byte) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Lock", arg0)
+	ret := m.ctrl.Call(m, "Store", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// Lock indicates an expected call of Lock.
-func (mr *MockLockStoreMockRecorder) Lock(arg0 any) *gomock.Call {
+// Store indicates an expected call of Store.
+func (mr *MockKVStoreMockRecorder) Store(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lock", reflect.TypeOf((*MockLockStore)(nil).Lock), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockKVStore)(nil).Store), arg0, arg1)
 }
 
-// Unlock mocks base method.
-func (m *MockLockStore) Unlock(arg0 string) error {
+// StoreTTL mocks base method.
+func (m *MockKVStore) StoreTTL(arg0 string, arg1 []byte, arg2 int64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Unlock", arg0)
+	ret := m.ctrl.Call(m, "StoreTTL", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// Unlock indicates an expected call of Unlock.
-func (mr *MockLockStoreMockRecorder) Unlock(arg0 any) *gomock.Call {
+// StoreTTL indicates an expected call of StoreTTL.
+func (mr *MockKVStoreMockRecorder) StoreTTL(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreTTL", reflect.TypeOf((*MockKVStore)(nil).StoreTTL), arg0, arg1, arg2)
+}
+
+// StoreWithOptions mocks base method.
+func (m *MockKVStore) StoreWithOptions(arg0 string, arg1 []byte, arg2 model.PluginKVSetOptions) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StoreWithOptions", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StoreWithOptions indicates an expected call of StoreWithOptions.
+func (mr *MockKVStoreMockRecorder) StoreWithOptions(arg0, arg1, arg2 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unlock", reflect.TypeOf((*MockLockStore)(nil).Unlock), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreWithOptions", reflect.TypeOf((*MockKVStore)(nil).StoreWithOptions), arg0, arg1, arg2)
 }
@@ -1,5 +1,7 @@
 package perror
 
+import "encoding/json"
+
 const internalServerError = "Internal error, please check logs"
 
 type PError struct {
@@ -8,12 +10,16 @@ type PError struct {
 }
 
 func (e *PError) Error() string {
-	if e.err == nil {
+	if e.err != nil {
 		return e.err.Error()
 	}
 	return ""
 }
 
+func (e *PError) Unwrap() error {
+	return e.err
+}
+
 func (e *PError) String() string {
 	return e.Error()
 }
@@ -23,7 +29,11 @@ func (e *PError) Message() string {
 }
 
 func (e *PError) AsJSON() string {
-	return `{"error": "` + e.Message() + `"}`
+	data, err := json.Marshal(map[string]string{"error": e.Message()})
+	if err != nil {
+		return `{"error": "` + internalServerError + `"}`
+	}
+	return string(data)
 }
 
 func NewPError(err error, message string) *PError {
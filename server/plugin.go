@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/api"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/command"
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
 	"github.com/mattermost/mattermost-plugin-bulk-invite/server/kvstore"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 
 	root "github.com/mattermost/mattermost-plugin-bulk-invite"
 )
@@ -33,13 +36,30 @@ type Plugin struct {
 	// HTTP
 	handler *api.Handler
 
+	// commandHandler handles the slash commands
+	commandHandler *command.Handler
+
 	// botUserID the userID for the user of the bot, used to send messages to channels
 	botUserID string
 
 	// engine the engine to use on bulk operations
 	engine *engine.Engine
+
+	// jobsKVStore the cached store of the jobs, invalidated by the other nodes of the cluster
+	jobsKVStore kvstore.CacheKVStore
+
+	// schedulerJob starts the scheduled operations, running in a single node of the cluster
+	schedulerJob *cluster.Job
 }
 
+const (
+	// schedulerJobKey the key of the cluster job starting the scheduled operations
+	schedulerJobKey = "scheduled_operations"
+
+	// jobsCacheTTL the time the jobs are cached in each node
+	jobsCacheTTL = 30 * time.Second
+)
+
 func (p *Plugin) OnActivate() error {
 	config := p.API.GetConfig()
 	license := p.API.GetLicense()
@@ -48,10 +68,47 @@ func (p *Plugin) OnActivate() error {
 		return fmt.Errorf("this plugin requires an Enterprise license")
 	}
 
+	lockStore := kvstore.NewLockStore(p.API)
+	p.jobsKVStore = kvstore.NewCacheKeyStore(kvstore.NewPluginStore(p.API), jobsCacheTTL, p.API)
+	jobStore := engine.NewJobStore(p.jobsKVStore)
+
+	p.engine = engine.NewEngine(p.API, lockStore, jobStore, p.botUserID)
+	p.engine.SetSettings(p.getConfiguration().engineSettings())
+
+	p.handler = api.NewHandler(p.API)
+	api.Init(p.handler, p.engine)
+
+	p.commandHandler = command.NewHandler(p.API, p.engine)
+	if err := p.commandHandler.Register(); err != nil {
+		return fmt.Errorf("error registering slash command: %w", err)
+	}
+
+	schedulerJob, err := cluster.Schedule(p.API, schedulerJobKey, cluster.MakeWaitForRoundedInterval(time.Minute), p.engine.RunSchedules)
+	if err != nil {
+		return fmt.Errorf("error scheduling the scheduled operations job: %w", err)
+	}
+	p.schedulerJob = schedulerJob
+
+	go func() {
+		if err := p.engine.ResumeJobs(); err != nil {
+			p.API.LogError("error resuming interrupted jobs", "err", err.Error())
+		}
+	}()
+
 	return nil
 }
 
 func (p *Plugin) OnDeactivate() error {
+	if p.schedulerJob != nil {
+		if err := p.schedulerJob.Close(); err != nil {
+			p.API.LogError("error closing the scheduled operations job", "err", err.Error())
+		}
+	}
+
+	if p.engine != nil {
+		p.engine.Stop()
+	}
+
 	return nil
 }
 
@@ -67,17 +124,14 @@ func (p *Plugin) OnConfigurationChange() error {
 
 	p.setConfiguration(configuration)
 
+	if p.engine != nil {
+		p.engine.SetSettings(configuration.engineSettings())
+	}
+
 	if err := p.ensureBot(); err != nil {
 		return fmt.Errorf("error ensuring bot is present: %w", err)
 	}
 
-	lockStore := kvstore.NewLockStore(p.API)
-
-	p.engine = engine.NewEngine(p.API, lockStore, p.botUserID)
-
-	p.handler = api.NewHandler(p.API)
-	api.Init(p.handler, p.engine)
-
 	return nil
 }
 
@@ -85,6 +139,17 @@ func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, req *http.R
 	p.handler.ServeHTTP(w, req)
 }
 
+// OnPluginClusterEvent is invoked when another node of the cluster publishes an event
+func (p *Plugin) OnPluginClusterEvent(_ *plugin.Context, ev model.PluginClusterEvent) {
+	if p.jobsKVStore != nil {
+		p.jobsKVStore.HandleClusterEvent(ev)
+	}
+}
+
+func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	return p.commandHandler.Execute(args), nil
+}
+
 // ensureBot ensures that the bot user is present in the system
 func (p *Plugin) ensureBot() error {
 	p.API.LogDebug("ensuring bot user is present")
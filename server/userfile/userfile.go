@@ -0,0 +1,240 @@
+// Package userfile parses the lists of users uploaded to run bulk operations
+package userfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/perror"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// MaxSizeKiloBytes the maximum size of the uploaded files
+	MaxSizeKiloBytes = 256
+
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatText = "text"
+
+	csvColumnUserID   = "user_id"
+	csvColumnUsername = "username"
+	csvColumnEmail    = "email"
+)
+
+// utf8BOM is added by some spreadsheet applications at the start of CSV exports
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Format detects the format of the uploaded file from its content type, falling back to the
+// file extension since browsers don't always send a meaningful content type.
+func Format(contentType, filename string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		switch mediaType {
+		case "application/json":
+			return FormatJSON
+		case "text/csv":
+			return FormatCSV
+		case "text/plain":
+			return FormatText
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return FormatJSON
+	case ".csv":
+		return FormatCSV
+	case ".txt":
+		return FormatText
+	}
+
+	return ""
+}
+
+// Parse reads the list of users from the uploaded file in the provided format
+func Parse(r io.Reader, format string) ([]engine.AddUser, *perror.PError) {
+	switch format {
+	case FormatJSON:
+		return parseUsersJSON(r)
+	case FormatCSV:
+		return parseUsersCSV(r)
+	case FormatText:
+		return parseUsersText(r)
+	}
+
+	return nil, perror.NewPError(fmt.Errorf("invalid file format"), "Invalid file type, only JSON, CSV and plain text are supported")
+}
+
+func parseUsersJSON(r io.Reader) ([]engine.AddUser, *perror.PError) {
+	var payload struct {
+		Users []engine.AddUser `json:"users"`
+	}
+
+	if err := json.NewDecoder(r).Decode(&payload); err != nil {
+		return nil, perror.NewPError(err, "Error parsing submitted file")
+	}
+
+	return payload.Users, nil
+}
+
+// parseUsersCSV reads users from a CSV file with a header row. The header must contain a user_id,
+// username or email column, other columns are ignored.
+func parseUsersCSV(r io.Reader) ([]engine.AddUser, *perror.PError) {
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(bom, utf8BOM) {
+		_, _ = br.Discard(len(utf8BOM))
+	}
+
+	reader := csv.NewReader(br)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, perror.NewPError(err, "The CSV file is empty.")
+		}
+		return nil, newCSVParseError(err)
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	userIDColumn, hasUserID := columns[csvColumnUserID]
+	usernameColumn, hasUsername := columns[csvColumnUsername]
+	emailColumn, hasEmail := columns[csvColumnEmail]
+	if !hasUserID && !hasUsername && !hasEmail {
+		return nil, perror.NewPError(
+			fmt.Errorf("missing csv columns"),
+			fmt.Sprintf("Line 1: the CSV header must contain a `%s`, `%s` or `%s` column.", csvColumnUserID, csvColumnUsername, csvColumnEmail),
+		)
+	}
+
+	users := []engine.AddUser{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, newCSVParseError(err)
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		var user engine.AddUser
+		if hasUserID {
+			user.UserID = strings.TrimSpace(record[userIDColumn])
+		}
+		if hasUsername {
+			user.Username = normalizeUsername(record[usernameColumn])
+		}
+		if hasEmail {
+			user.Email = normalizeEmail(record[emailColumn])
+		}
+
+		switch {
+		case user.UserID != "":
+			if !model.IsValidId(user.UserID) {
+				return nil, newLineParseError(line, fmt.Sprintf("invalid user_id `%s`", user.UserID))
+			}
+		case user.Username != "":
+			if !model.IsValidUsername(user.Username) {
+				return nil, newLineParseError(line, fmt.Sprintf("invalid username `%s`", user.Username))
+			}
+		case user.Email != "":
+			if !model.IsValidEmail(user.Email) {
+				return nil, newLineParseError(line, fmt.Sprintf("invalid email `%s`", user.Email))
+			}
+		default:
+			return nil, newLineParseError(line, "missing user_id, username or email")
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// parseUsersText reads one username or email per line, empty lines are ignored
+func parseUsersText(r io.Reader) ([]engine.AddUser, *perror.PError) {
+	users := []engine.AddUser{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, string(utf8BOM))
+		}
+		if text == "" {
+			continue
+		}
+
+		user, err := ParseUser(text)
+		if err != nil {
+			return nil, newLineParseError(line, err.Error())
+		}
+
+		users = append(users, user)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, perror.NewPError(err, "Error parsing submitted file")
+	}
+
+	return users, nil
+}
+
+// ParseUser parses a username, optionally prefixed with @, or an email
+func ParseUser(text string) (engine.AddUser, error) {
+	// Usernames can be prefixed with @, emails have it in the middle
+	if strings.Contains(strings.TrimPrefix(text, "@"), "@") {
+		email := normalizeEmail(text)
+		if !model.IsValidEmail(email) {
+			return engine.AddUser{}, fmt.Errorf("invalid email `%s`", text)
+		}
+
+		return engine.AddUser{Email: email}, nil
+	}
+
+	username := normalizeUsername(text)
+	if !model.IsValidUsername(username) {
+		return engine.AddUser{}, fmt.Errorf("invalid username `%s`", text)
+	}
+
+	return engine.AddUser{Username: username}, nil
+}
+
+// normalizeUsername removes the mention prefix from usernames, which are always lowercase
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+// normalizeEmail emails are matched case insensitively
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func newLineParseError(line int, reason string) *perror.PError {
+	return perror.NewPError(fmt.Errorf("line %d: %s", line, reason), fmt.Sprintf("Line %d: %s.", line, reason))
+}
+
+func newCSVParseError(err error) *perror.PError {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return newLineParseError(parseErr.Line, parseErr.Err.Error())
+	}
+
+	return perror.NewPError(err, "Error parsing submitted file")
+}
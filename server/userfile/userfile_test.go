@@ -0,0 +1,85 @@
+package userfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-bulk-invite/server/engine"
+	"github.com/stretchr/testify/require"
+)
+
+const testUserID = "abcdefghijklmnopqrstuvwxyz"
+
+func TestFormat(t *testing.T) {
+	require.Equal(t, FormatJSON, Format("application/json", "users"))
+	require.Equal(t, FormatCSV, Format("text/csv; charset=utf-8", "users"))
+	require.Equal(t, FormatText, Format("text/plain", "users"))
+	require.Equal(t, FormatCSV, Format("application/vnd.ms-excel", "users.CSV"))
+	require.Equal(t, "", Format("application/octet-stream", "users.xlsx"))
+}
+
+func TestParseUsersCSV(t *testing.T) {
+	t.Run("detects columns from header", func(t *testing.T) {
+		input := string(utf8BOM) + "Name, Username ,user_id,EMAIL\n" +
+			"John,@John,,\n" +
+			"Jane,," + testUserID + ",\n" +
+			"Bob,,,Bob@Example.com\n"
+
+		users, err := Parse(strings.NewReader(input), FormatCSV)
+		require.Nil(t, err)
+		require.Equal(t, []engine.AddUser{
+			{Username: "john"},
+			{UserID: testUserID},
+			{Email: "bob@example.com"},
+		}, users)
+	})
+
+	t.Run("missing columns", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("name,mail\njohn,john@example.com\n"), FormatCSV)
+		require.NotNil(t, err)
+		require.Contains(t, err.Message(), "Line 1")
+	})
+
+	t.Run("empty row reports line number", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("username,user_id\njohn,\n,\n"), FormatCSV)
+		require.NotNil(t, err)
+		require.Equal(t, "Line 3: missing user_id, username or email.", err.Message())
+	})
+
+	t.Run("invalid user id reports line number", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("user_id\n"+testUserID+"\nnot-an-id\n"), FormatCSV)
+		require.NotNil(t, err)
+		require.Equal(t, "Line 3: invalid user_id `not-an-id`.", err.Message())
+	})
+
+	t.Run("malformed csv reports line number", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("username\njohn\n\"jane\n"), FormatCSV)
+		require.NotNil(t, err)
+		require.Contains(t, err.Message(), "Line 3")
+	})
+}
+
+func TestParseUsersText(t *testing.T) {
+	t.Run("one username or email per line", func(t *testing.T) {
+		users, err := Parse(strings.NewReader("john\n\n  @jane  \r\nBob\nAlice@Example.com\n"), FormatText)
+		require.Nil(t, err)
+		require.Equal(t, []engine.AddUser{
+			{Username: "john"},
+			{Username: "jane"},
+			{Username: "bob"},
+			{Email: "alice@example.com"},
+		}, users)
+	})
+
+	t.Run("invalid email reports line number", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("john\njane@\n"), FormatText)
+		require.NotNil(t, err)
+		require.Equal(t, "Line 2: invalid email `jane@`.", err.Message())
+	})
+
+	t.Run("invalid username reports line number", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("john\njane doe\n"), FormatText)
+		require.NotNil(t, err)
+		require.Equal(t, "Line 2: invalid username `jane doe`.", err.Message())
+	})
+}
@@ -156,7 +156,7 @@ const ActualForm = (props: ActualFormProps) => {
 
     const components: FormComponentProps[] = [
         {
-            label: 'File (.JSON format)',
+            label: 'File (.JSON, .CSV or .TXT format)',
             required: true,
             helpText: <div>
                 <a
@@ -173,7 +173,7 @@ const ActualForm = (props: ActualFormProps) => {
                         }
                     }}
                     type='file'
-                    accept='.json'
+                    accept='.json,.csv,.txt'
                 />
             ),
         },